@@ -67,6 +67,7 @@ func main() {
 		MqttPassword:   viperGetString("MQTT_PASSWORD"),
 		InfluxdbUrl:    viperGetString("INFLUXDB_URL"),
 		InfluxdbApikey: viperGetString("INFLUXDB_APIKEY"),
+		AlertRulesFile: viperGetString("ALERT_RULES"),
 	}
 
 	fmt.Println(config)
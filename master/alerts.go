@@ -0,0 +1,387 @@
+package master
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	alertsTopic       = "ALERTS"
+	alertMaxResolved  = 1000
+	defaultAlertLevel = AlertWarning
+)
+
+// Alert severities
+const (
+	AlertInfo     = "info"
+	AlertWarning  = "warning"
+	AlertCritical = "critical"
+)
+
+// alertFields are the telemetry values rules can watch
+var alertFields = map[string]string{
+	"Uop":      "V, PSU output voltage",
+	"Iop":      "A, PSU output current",
+	"Pop":      "W, PSU output power",
+	"Uip":      "V, PSU input voltage",
+	"Temp":     "°C, PSU temperature, when the car reports it",
+	"Spd":      "GPS speed",
+	"Accel":    "acceleration magnitude",
+	"PSUAge":   "s since the last PSU reading",
+	"GPSAge":   "s since the last GPS fix",
+	"AccelAge": "s since the last acceleration reading",
+}
+
+var alertComparators = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// AlertRule fires an alert for a car once Field Comparator Threshold has held for Duration
+type AlertRule struct {
+	ID         string   `json:"ID"`
+	Field      string   `json:"Field"`
+	Comparator string   `json:"Comparator"` // >, >=, <, <=, == or !=
+	Threshold  float64  `json:"Threshold"`
+	Duration   float64  `json:"Duration"`        // s, 0 to fire on the first sample
+	Cars       []string `json:"Cars,omitempty"`  // only these cars, every car when empty
+	Class      string   `json:"Class,omitempty"` // only cars of this class
+	Severity   string   `json:"Severity"`        // info, warning (default) or critical
+	Message    string   `json:"Message,omitempty"`
+	Publish    bool     `json:"Publish"` // also publish on ALERTS/<carID>
+}
+
+type Alert struct {
+	ID         int       `json:"ID"`
+	RuleID     string    `json:"RuleID"`
+	CarID      string    `json:"CarID"`
+	Field      string    `json:"Field"`
+	Comparator string    `json:"Comparator"`
+	Threshold  float64   `json:"Threshold"`
+	Value      float64   `json:"Value"` // last value that met the rule
+	Severity   string    `json:"Severity"`
+	Message    string    `json:"Message,omitempty"`
+	Active     bool      `json:"Active"`
+	Since      time.Time `json:"Since"` // when the condition started to hold
+	FiredAt    time.Time `json:"Fired at"`
+	ClearedAt  time.Time `json:"Cleared at"`
+	Acked      bool      `json:"Acked"`
+	AckedBy    string    `json:"Acked by,omitempty"`
+	AckedAt    time.Time `json:"Acked at"`
+}
+
+// alertKey identifies the alert of one rule on one car
+type alertKey struct {
+	ruleID string
+	carID  string
+}
+
+type alertEngine struct {
+	mutex    sync.Mutex
+	seq      int
+	pending  map[alertKey]time.Time // when the condition started to hold
+	active   map[alertKey]*Alert
+	resolved []Alert // oldest first
+}
+
+func (r AlertRule) Validate() error {
+	if r.ID == "" {
+		return fmt.Errorf("alert rule ID is empty")
+	}
+	if _, ok := alertFields[r.Field]; !ok {
+		return fmt.Errorf("alert rule '%s': unknown field '%s'", r.ID, r.Field)
+	}
+	if _, ok := alertComparators[r.Comparator]; !ok {
+		return fmt.Errorf("alert rule '%s': unknown comparator '%s'", r.ID, r.Comparator)
+	}
+	if r.Duration < 0 {
+		return fmt.Errorf("alert rule '%s': duration must not be negative", r.ID)
+	}
+	switch r.Severity {
+	case "", AlertInfo, AlertWarning, AlertCritical:
+	default:
+		return fmt.Errorf("alert rule '%s': unknown severity '%s'", r.ID, r.Severity)
+	}
+	return nil
+}
+
+// applies reports whether the rule covers the car
+func (r AlertRule) applies(carID, classID string) bool {
+	if r.Class != "" && !strings.EqualFold(r.Class, classID) {
+		return false
+	}
+	if len(r.Cars) == 0 {
+		return true
+	}
+	for _, id := range r.Cars {
+		if id == carID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r AlertRule) message(carID string, v float64) string {
+	if r.Message != "" {
+		return r.Message
+	}
+	return fmt.Sprintf("car %s: %s %.2f %s %.2f", carID, r.Field, v, r.Comparator, r.Threshold)
+}
+
+func (a *AllData) GetAlertRules() []AlertRule {
+	rules := make([]AlertRule, 0, len(a.AlertRules))
+	for _, r := range a.AlertRules {
+		rules = append(rules, r)
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+// UpdateAlertRules replaces the alert rules
+func (a *AllData) UpdateAlertRules(rules []AlertRule) error {
+	updated := make(map[string]AlertRule, len(rules))
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		if _, ok := updated[r.ID]; ok {
+			return fmt.Errorf("alert rule '%s' is defined more than once", r.ID)
+		}
+		if r.Severity == "" {
+			r.Severity = defaultAlertLevel
+		}
+		updated[r.ID] = r
+	}
+	a.AlertRules = updated
+	return nil
+}
+
+// LoadAlertRules reads rules from a JSON file, they are used when no rules were set through the API
+func (a *AllData) LoadAlertRules(path string) error {
+	if len(a.AlertRules) > 0 {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "ReadFile")
+	}
+	var rules []AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return errors.Wrap(err, "Unmarshal")
+	}
+	return a.UpdateAlertRules(rules)
+}
+
+// evaluate checks the car's values against the rules. It returns the alerts that fired or cleared.
+func (e *alertEngine) evaluate(rules []AlertRule, carID, classID string, values map[string]float64, at time.Time) []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.pending == nil {
+		e.pending = make(map[alertKey]time.Time)
+		e.active = make(map[alertKey]*Alert)
+	}
+
+	var changed []Alert
+	for _, r := range rules {
+		v, ok := values[r.Field]
+		if !ok || !r.applies(carID, classID) {
+			continue
+		}
+		key := alertKey{ruleID: r.ID, carID: carID}
+		if !alertComparators[r.Comparator](v, r.Threshold) {
+			delete(e.pending, key)
+			if a, ok := e.active[key]; ok {
+				a.Active, a.ClearedAt = false, at
+				changed = append(changed, *a)
+				e.resolve(key)
+			}
+			continue
+		}
+		if a, ok := e.active[key]; ok {
+			a.Value = v
+			continue
+		}
+		since, ok := e.pending[key]
+		if !ok {
+			since = at
+			e.pending[key] = since
+		}
+		if at.Sub(since).Seconds() < r.Duration {
+			continue
+		}
+		delete(e.pending, key)
+		e.seq++
+		a := &Alert{
+			ID: e.seq, RuleID: r.ID, CarID: carID, Field: r.Field, Comparator: r.Comparator, Threshold: r.Threshold,
+			Value: v, Severity: r.Severity, Message: r.message(carID, v), Active: true, Since: since, FiredAt: at,
+		}
+		e.active[key] = a
+		changed = append(changed, *a)
+	}
+	return changed
+}
+
+// resolve moves an active alert to the resolved ones, the caller holds the lock
+func (e *alertEngine) resolve(key alertKey) {
+	e.resolved = append(e.resolved, *e.active[key])
+	if len(e.resolved) > alertMaxResolved {
+		e.resolved = e.resolved[len(e.resolved)-alertMaxResolved:]
+	}
+	delete(e.active, key)
+}
+
+// prune clears the active alerts of rules that no longer exist
+func (e *alertEngine) prune(rules map[string]AlertRule, at time.Time) []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	var cleared []Alert
+	for key, a := range e.active {
+		if _, ok := rules[a.RuleID]; !ok {
+			a.Active, a.ClearedAt = false, at
+			cleared = append(cleared, *a)
+			e.resolve(key)
+		}
+	}
+	for key := range e.pending {
+		if _, ok := rules[key.ruleID]; !ok {
+			delete(e.pending, key)
+		}
+	}
+	return cleared
+}
+
+func (e *alertEngine) ack(id int, by string, at time.Time) (Alert, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, a := range e.active {
+		if a.ID == id {
+			if !a.Acked {
+				a.Acked, a.AckedBy, a.AckedAt = true, by, at
+			}
+			return *a, nil
+		}
+	}
+	return Alert{}, fmt.Errorf("no active alert with ID %d", id)
+}
+
+func (e *alertEngine) activeAlerts() []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	alerts := make([]Alert, 0, len(e.active))
+	for _, a := range e.active {
+		alerts = append(alerts, *a)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].ID < alerts[j].ID })
+	return alerts
+}
+
+// history returns the resolved alerts, of one car when carID is not empty, oldest first
+func (e *alertEngine) history(carID string) []Alert {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	alerts := []Alert{}
+	for _, a := range e.resolved {
+		if carID == "" || a.CarID == carID {
+			alerts = append(alerts, a)
+		}
+	}
+	return alerts
+}
+
+func (srv *Service) GetAlerts() []Alert {
+	return srv.alerts.activeAlerts()
+}
+
+func (srv *Service) GetAlertHistory(carID string) []Alert {
+	return srv.alerts.history(carID)
+}
+
+// UpdateAlertRules replaces the rules and clears the alerts of removed rules
+func (srv *Service) UpdateAlertRules(rules []AlertRule) error {
+	if err := srv.AllData.UpdateAlertRules(rules); err != nil {
+		return err
+	}
+	for _, a := range srv.alerts.prune(srv.AllData.AlertRules, time.Now()) {
+		srv.alertChanged(a, nil)
+	}
+	return nil
+}
+
+func (srv *Service) AckAlert(id int, by string) (Alert, error) {
+	a, err := srv.alerts.ack(id, by, time.Now())
+	if err != nil {
+		return a, err
+	}
+	srv.alertChanged(a, nil)
+	return a, nil
+}
+
+// checkAlerts runs a car's telemetry values through the alert rules
+func (srv *Service) checkAlerts(carID string, values map[string]float64, at time.Time) {
+	if len(srv.AllData.AlertRules) == 0 {
+		return
+	}
+	var classID string
+	if car, ok := srv.AllData.CarMap[carID]; ok {
+		classID, _ = srv.AllData.ClassID(car.Params.AgeGroup)
+	}
+	rules := srv.AllData.GetAlertRules()
+	for _, a := range srv.alerts.evaluate(rules, carID, classID, values, at) {
+		r := srv.AllData.AlertRules[a.RuleID]
+		srv.alertChanged(a, &r)
+	}
+}
+
+// checkAlertAges runs the time since each sensor last reported through the alert rules, the ingest path
+// cannot notice a sensor that went silent
+func (srv *Service) checkAlertAges(now time.Time) {
+	for carID := range srv.AllData.CarMap {
+		t, ok := srv.telemetry.get(carID)
+		if !ok {
+			continue
+		}
+		values := map[string]float64{}
+		for sensor, field := range map[string]string{SensorPSU: "PSUAge", SensorGPS: "GPSAge", SensorAccel: "AccelAge"} {
+			if at, ok := t.LastSeen[sensor]; ok {
+				values[field] = now.Sub(at).Seconds()
+			}
+		}
+		srv.checkAlerts(carID, values, now)
+	}
+}
+
+// alertChanged logs an alert and sends it to the alerts and car topics, and publishes it on MQTT when its rule asks for it
+func (srv *Service) alertChanged(a Alert, r *AlertRule) {
+	switch {
+	case a.Active && a.Acked:
+		logrus.Infof("Alert %d acknowledged by %s: %s", a.ID, a.AckedBy, a.Message)
+	case a.Active:
+		logrus.Warnf("Alert %d %s: %s", a.ID, a.Severity, a.Message)
+	default:
+		logrus.Infof("Alert %d cleared: %s", a.ID, a.Message)
+	}
+
+	Publish("alert", a, TopicAlerts, carTopic(a.CarID))
+
+	if r != nil && r.Publish && srv.mqtt != nil {
+		msg, err := json.Marshal(a)
+		if err == nil {
+			err = srv.sendAnyTopic(fmt.Sprintf("%s/%s", alertsTopic, a.CarID), msg)
+		}
+		if err != nil {
+			logrus.WithError(errors.Wrap(err, alertsTopic)).Error("Error")
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertEvaluate(t *testing.T) {
+	t0 := time.Now()
+	at := func(s float64) time.Time { return t0.Add(time.Duration(s * float64(time.Second))) }
+	rules := []AlertRule{
+		{ID: "low", Field: "Uip", Comparator: "<", Threshold: 20, Duration: 2, Severity: AlertCritical},
+		{ID: "hot", Field: "Temp", Comparator: ">=", Threshold: 70, Class: "junior"},
+		{ID: "car2", Field: "Iop", Comparator: ">", Threshold: 10, Cars: []string{"2"}},
+	}
+
+	var e alertEngine
+	assert.Empty(t, e.evaluate(rules, "1", "", map[string]float64{"Uip": 19, "Iop": 12}, at(0)), "duration not reached, car out of scope")
+	assert.Empty(t, e.evaluate(rules, "1", "", map[string]float64{"Uip": 18}, at(1)))
+
+	fired := e.evaluate(rules, "1", "", map[string]float64{"Uip": 18}, at(2))
+	assert.Len(t, fired, 1)
+	assert.Equal(t, "low", fired[0].RuleID)
+	assert.True(t, fired[0].Active)
+	assert.Equal(t, at(0), fired[0].Since)
+
+	assert.Empty(t, e.evaluate(rules, "1", "", map[string]float64{"Temp": 80}, at(2)), "class out of scope")
+	assert.Len(t, e.evaluate(rules, "1", "Junior", map[string]float64{"Temp": 80}, at(2)), 1)
+	assert.Len(t, e.activeAlerts(), 2)
+
+	_, err := e.ack(fired[0].ID, "official", at(3))
+	assert.NoError(t, err)
+	cleared := e.evaluate(rules, "1", "", map[string]float64{"Uip": 24}, at(4))
+	assert.Len(t, cleared, 1)
+	assert.False(t, cleared[0].Active)
+	assert.True(t, cleared[0].Acked)
+	assert.Len(t, e.history("1"), 1)
+
+	assert.Len(t, e.prune(map[string]AlertRule{"low": rules[0]}, at(5)), 1, "hot was removed")
+	assert.Empty(t, e.activeAlerts())
+}
+
+func TestAlertPruneRuleIDWithSlash(t *testing.T) {
+	t0 := time.Now()
+	rules := []AlertRule{{ID: "gps/loss", Field: "Sats", Comparator: "<", Threshold: 4, Duration: 10}}
+
+	var e alertEngine
+	assert.Empty(t, e.evaluate(rules, "1", "", map[string]float64{"Sats": 2}, t0))
+	assert.Empty(t, e.prune(map[string]AlertRule{"gps/loss": rules[0]}, t0), "the rule still exists")
+	assert.Len(t, e.evaluate(rules, "1", "", map[string]float64{"Sats": 2}, t0.Add(10*time.Second)), 1, "pending kept by the prune")
+
+	assert.Len(t, e.prune(map[string]AlertRule{}, t0.Add(11*time.Second)), 1)
+	assert.Empty(t, e.activeAlerts())
+	assert.Empty(t, e.pending)
+}
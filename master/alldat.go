@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -14,20 +13,25 @@ import (
 )
 
 type AllData struct {
-	UUID          uuid.UUID // Unique identifier for this instance
-	LastSave      time.Time // Timestamp of last file save
-	Settings      Settings
-	CarMap        map[string]Car                // map of [carID]
-	Races         map[string]Race               // map of [raceName_Lap]
-	Leaderboards  map[string][]LeaderboardEntry // map of [ageGroup]
-	LiveData      map[string]LiveDataInstance   // map of [carID]
-	LiveDataMutex sync.Mutex                    // Mutex to protect LiveData access
+	UUID                 uuid.UUID // Unique identifier for this instance
+	LastSave             time.Time // Timestamp of last file save
+	Settings             Settings
+	CarMap               map[string]Car                   // map of [carID]
+	Races                map[string]Race                  // map of [raceName_Lap]
+	Leaderboards         map[string][]LeaderboardEntry    // map of [ageGroup]
+	LeaderboardSnapshots map[string][]LeaderboardSnapshot // map of [ageGroup]
+	Baselines            map[string][]LeaderboardEntry    // map of [ageGroup], the leaderboards relative positions are computed against
+	Classes              map[string]Class                 // map of [classID]
+	Tracks               map[string]Track                 // map of [trackID]
+	AlertRules           map[string]AlertRule             // map of [ruleID]
+	EStop                EStop                            // emergency stop, kept across restarts
+	LiveData             map[string]LiveDataInstance      // map of [carID]
+	LiveDataMutex        sync.Mutex                       // Mutex to protect LiveData access
 }
 
 type Car struct {
-	Params            Parameters
-	CurrentRace       *Race
-	SpeedTestIterator int
+	Params      Parameters
+	CurrentRace *Race
 }
 
 type Parameters struct {
@@ -38,22 +42,38 @@ type Parameters struct {
 	MaxCurrent float64 `json:"I"`
 	Mass       float64 `json:"m"`
 	AgeGroup   string  `json:"ageGroup"`
+	GPSFormat  string  `json:"gpsFormat"` // decimal (default), nmea, nmea100 or sentence
 }
 
 type RaceData struct {
-	Position    int
-	Points      int
-	TotalWh     float64
-	RaceTime    time.Duration
-	FactualTime time.Duration // time spent in
-	RaceMode    bool
-	Finished    bool
-	timer       time.Time
+	Position       int
+	Points         int
+	AutoPoints     int  // points computed from the race's scoring rules
+	PointsOverride bool // Points were entered manually and take precedence over AutoPoints
+	TotalWh        float64
+	RaceTime       time.Duration
+	FactualTime    time.Duration // time spent in
+	RaceMode       bool
+	Finished       bool
+	StartedAt      time.Time
+	Penalties      []Penalty
+	Laps           []LapTime // track laps detected from GPS
+	Distance       float64   // m, travelled according to GPS
+	PitStops       []PitStop
+	Stints         []Stint
+	timer          time.Time
+	track          lapTracker
+	odometer       odometer
+	pitLane        pitLaneState
+	nextDriver     string // takes over at pit-out
 }
 
 type Result struct {
 	RaceName    string        `json:"RaceName"`
 	Lap         int           `json:"Lap"`
+	Position    int           `json:"Position"` // 0 when the car did not finish
+	Tied        bool          `json:"Tied"`     // another car shares the same position
+	Status      string        `json:"Status"`   // OK, DNF, DNS or DSQ
 	CarID       string        `json:"ID"`
 	Username    string        `json:"Username"`
 	Avatar      string        `json:"avatar"`
@@ -63,6 +83,14 @@ type Result struct {
 	AvgPower    float64       `json:"Average power"`
 	AvgSpeed    float64       `json:"Average speed"`
 	ElapsedTime time.Duration `json:"Elapsed time"`
+	Points      int           `json:"Points"` // after point penalties
+	Penalties   []Penalty     `json:"Penalties,omitempty"`
+	Laps        []LapTime     `json:"Laps,omitempty"`
+	Distance    float64       `json:"Distance"`          // m, nominal race length
+	Measured    float64       `json:"Measured distance"` // m, travelled according to GPS
+	PitStops    []PitStop     `json:"PitStops,omitempty"`
+	PitTime     time.Duration `json:"Pit time"`
+	Stints      []Stint       `json:"Stints,omitempty"`
 }
 
 // [
@@ -86,15 +114,26 @@ type LeaderboardEntry struct {
 	Avatar     string   `json:"avatar"`
 	Categories []string `json:"Category names"`
 	Points     []int    `json:"Category points"`
+	Total      int      `json:"Total"`         // raw sum of all category points
+	Counted    float64  `json:"Counted total"` // total after championship weights and dropped results
+	Dropped    []string `json:"Dropped categories"`
+	Wins       int      `json:"Wins"`
+	BestFinish int      `json:"Best finish"` // best race position, 0 when the car never finished
 	Position   int      `json:"Position"`
 	RelPos     int      `json:"Relative Position"`
 }
 
 type Race struct {
-	RaceName string              `json:"RaceName"`
-	Lap      int                 `json:"Lap"`
-	Length   float64             `json:"Length"`
-	RaceData map[string]RaceData `json:"RaceData"` // map of [carID]
+	RaceName        string              `json:"RaceName"`
+	Lap             int                 `json:"Lap"`
+	Length          float64             `json:"Length"`          // m
+	LengthFromTrack bool                `json:"LengthFromTrack"` // Length is derived from the track, false with a Length to override it
+	Scoring         ScoringRules        `json:"Scoring"`
+	TrackID         string              `json:"TrackID"`        // empty when laps are not detected from GPS
+	Laps            int                 `json:"Laps"`           // track laps after which the car finishes automatically, 0 to finish manually
+	DistanceSource  string              `json:"DistanceSource"` // nominal (default) or measured distance for efficiency and speed
+	Pit             PitRules            `json:"Pit"`
+	RaceData        map[string]RaceData `json:"RaceData"` // map of [carID]
 }
 
 type StartInstance struct {
@@ -118,8 +157,14 @@ type Points struct {
 }
 
 type Settings struct {
-	RaceCoeficient float64 `json:"PowerCoef"`
-	MaxSpeed       float64 `json:"MaxSpd"`
+	RaceCoeficient float64           `json:"PowerCoef"`
+	MaxSpeed       float64           `json:"MaxSpd"`     // speed governor limit, 0 to turn the governor off
+	Governor       GovernorRules     `json:"Governor"`   // what the governor does above MaxSpd
+	PSURefresh     float64           `json:"PSURefresh"` // s, interval of re-publishing unchanged setpoints, 0 for the default
+	Telemetry      TelemetryRules    `json:"Telemetry"`  // when sensors go stale
+	LiveRate       float64           `json:"LiveRate"`   // Hz, live data updates over the WebSocket, 0 for the default of 1
+	Limits         LimitRules        `json:"Limits"`     // default PSU limit rules, classes may replace them
+	Championship   ChampionshipRules `json:"Championship"`
 }
 
 //	{
@@ -130,6 +175,7 @@ type Settings struct {
 //	    "CategoryNames": ["CategoryA","CategoryB","CategoryC","CategoryD"],
 //	    "CategoryPoints": [10,15,8,1],
 //	    "status": "online",
+//	    "stale": [],
 //	    "position": 1,
 //	    "lat": 56.660211339105715,
 //	    "lon": 23.744293111677134,
@@ -153,6 +199,16 @@ type LiveDataInstance struct {
 	Power      float64   `json:"power"`
 	Accel      float64   `json:"acceleration"`
 	Voltage    float64   `json:"voltage"`
+	Penalties  []Penalty `json:"penalties"`
+	InPit      bool      `json:"inPit"`
+	PitStops   int       `json:"pitStops"`
+	Driver     string    `json:"driver"`
+	PSUState   string    `json:"psuState"`  // pending, applied or mismatch
+	OverLimit  bool      `json:"overLimit"` // running above the allotted current
+	Governor   string    `json:"governor"`  // speed governor intervention in force, empty when there is none
+	EStop      bool      `json:"estop"`     // the emergency stop is in force
+	Status     string    `json:"status"`    // telemetry online, degraded or offline
+	Stale      []string  `json:"stale"`     // sensors that went silent
 	UpdatedAt  time.Time `json:"updatedAt"`
 }
 
@@ -188,29 +244,19 @@ func (a *AllData) UpdateCars(cars []Parameters, srv *Service) {
 		if _, ok := found[carID]; !ok {
 			delete(a.CarMap, carID)
 			logrus.Debugf("Car %s was removed from CarMap", carID)
+			if err := srv.clearSetpoint(carID); err != nil {
+				logrus.WithError(err).Error("Error")
+			}
+			srv.governor.remove(carID)
+			srv.commands.remove(carID)
+			srv.telemetry.remove(carID)
 		}
 	}
-	// Update PSU data for all registered cars based on new settings
 	for carID, car := range a.CarMap {
-		// Calculate max current
-		if car.Params.SetVoltage <= 0 {
-			logrus.Warnf("SetVoltage for car %s is zero or negative, skipping PSU update", carID)
-			continue
-		}
-		maxCurrent := car.Params.Mass * a.Settings.RaceCoeficient / car.Params.SetVoltage
-		car.Params.MaxCurrent = maxCurrent
-
-		payload := dataOutPSU{
-			U:      float32(car.Params.SetVoltage),
-			I:      float32(car.Params.MaxCurrent),
-			Status: 1,
-		}
-
 		srv.AllData.AddCarToLiveData(carID, car.Params.Username, car.Params.Avatar)
-
-		srv.sendPSUData(carID, payload)
 	}
-
+	// Update PSU data for all registered cars based on the new parameters
+	srv.publishCarSetpoints()
 }
 
 func raceKey(r Race) string {
@@ -238,6 +284,12 @@ func (a *AllData) UpdateRaces(races []Race) {
 		if existingRace, ok := a.Races[key]; ok {
 			existingRace.Lap = race.Lap
 			existingRace.Length = race.Length
+			existingRace.LengthFromTrack = race.LengthFromTrack
+			existingRace.Scoring = race.Scoring
+			existingRace.TrackID = race.TrackID
+			existingRace.Laps = race.Laps
+			existingRace.DistanceSource = race.DistanceSource
+			existingRace.Pit = race.Pit
 			a.Races[key] = existingRace
 		} else {
 			race.RaceData = make(map[string]RaceData)
@@ -253,6 +305,25 @@ func (a *AllData) UpdateRaces(races []Race) {
 			logrus.Debugf("Race %s was removed from Races", raceName)
 		}
 	}
+	a.deriveRaceLengths()
+}
+
+func raceMetrics(race Race, car Car, data RaceData) RaceMetrics {
+	var m RaceMetrics
+	wh, raceTime := data.PenalizedWh(), data.PenalizedTime()
+	km := race.ScoringDistance(data) / 1000
+	if km <= 0 || raceTime <= 0 {
+		return m
+	}
+	if car.Params.Mass > 0 {
+		m.Efficiency = wh / km / car.Params.Mass // Wh/km/kg
+	}
+	if wh > 0 {
+		m.ShellEff = km / (wh / 1000) // km/kWh
+	}
+	m.AvgPower = wh / raceTime.Hours() // W
+	m.AvgSpeed = km / raceTime.Hours() // km/h
+	return m
 }
 
 func (a *AllData) GetResults(raceName string) ([]Result, error) {
@@ -261,34 +332,44 @@ func (a *AllData) GetResults(raceName string) ([]Result, error) {
 		return nil, fmt.Errorf("race '%s' not found", raceName)
 	}
 
+	ranked := a.RankRace(race)
+	shared := map[int]int{}
+	for _, rc := range ranked {
+		if rc.Position > 0 {
+			shared[rc.Position]++
+		}
+	}
+
+	// Finished cars in ranking order, followed by the ones that did not finish
 	results := make([]Result, 0, len(race.RaceData))
-	for carID, data := range race.RaceData {
-		if car, exists := a.CarMap[carID]; exists {
-			var efficiency, shellEff, avgPower, avgSpeed float64
-			// Calculate efficiency metrics
-			if race.Length > 0 && data.RaceTime > 0 {
-				efficiency = data.TotalWh / (race.Length / 1000) / car.Params.Mass
-				shellEff = (race.Length / 1000) / (data.TotalWh / 1000)
-				avgPower = data.TotalWh / data.RaceTime.Hours()
-				avgSpeed = (race.Length / 1000) / data.RaceTime.Hours()
-			}
-			// efficiency := data.TotalWh / (race.Length / 1000) / car.Params.Mass // Wh/km/kg
-			// shellEff := (race.Length / 1000) / (data.TotalWh / 1000)            // km/kWh
-			// avgPower := data.TotalWh / data.RaceTime.Hours()                    // W
-			// avgSpeed := (race.Length / 1000) / data.RaceTime.Hours()            // km/h
+	for _, rc := range ranked {
+		data := race.RaceData[rc.CarID]
+		if car, exists := a.CarMap[rc.CarID]; exists {
+			metrics := rc.Metrics
 
 			result := Result{
 				RaceName:    race.RaceName,
 				Lap:         race.Lap,
+				Position:    rc.Position,
+				Tied:        shared[rc.Position] > 1,
+				Status:      rc.Status,
 				CarID:       car.Params.CarID,
 				Username:    car.Params.Username,
 				Avatar:      car.Params.Avatar,
-				UsedEnergy:  data.TotalWh,
-				Efficiency:  efficiency,
-				ShellEff:    shellEff,
-				AvgPower:    avgPower,
-				AvgSpeed:    avgSpeed,
-				ElapsedTime: data.RaceTime,
+				UsedEnergy:  data.PenalizedWh(),
+				Efficiency:  metrics.Efficiency,
+				ShellEff:    metrics.ShellEff,
+				AvgPower:    metrics.AvgPower,
+				AvgSpeed:    metrics.AvgSpeed,
+				ElapsedTime: data.PenalizedTime(),
+				Points:      data.NetPoints(),
+				Penalties:   data.ActivePenalties(),
+				Laps:        data.Laps,
+				Distance:    race.Length,
+				Measured:    data.Distance,
+				PitStops:    data.PitStops,
+				PitTime:     data.PitTime(),
+				Stints:      data.Stints,
 			}
 			results = append(results, result)
 		}
@@ -296,100 +377,13 @@ func (a *AllData) GetResults(raceName string) ([]Result, error) {
 	return results, nil
 }
 
-func (a *AllData) UpdateLeaderboard() error {
-	// Group cars by age group
-	ageGroups := make(map[string][]Car)
-	allCars := []Car{}
-
-	for _, car := range a.CarMap {
-		ageGroup := car.Params.AgeGroup
-		ageGroups[ageGroup] = append(ageGroups[ageGroup], car)
-		allCars = append(allCars, car) // Add to overall group
-	}
-	ageGroups["all"] = allCars // Add "all" group for overall leaderboard
-
-	categs := map[string][]string{}
-	pts := map[string][]int{}
-
-	// Process each age group (including "all" string for overall)
-	for ageGroup := range ageGroups {
-		entries := make([]LeaderboardEntry, 0)
-		cars := ageGroups[ageGroup]
-
-		// Create entries for each car
-		for _, car := range cars {
-			var categories []string
-			var points []int
-			validPoints := false
-
-			// Collect race data
-			for _, race := range a.Races {
-				if raceData, participated := race.RaceData[car.Params.CarID]; participated {
-					if raceData.Points >= 0 {
-						categories = append(categories, race.RaceName)
-						points = append(points, raceData.Points)
-						validPoints = true
-					}
-				}
-			}
-
-			// Only add cars with valid points
-			if validPoints {
-				entry := LeaderboardEntry{
-					CarID:      car.Params.CarID,
-					Username:   car.Params.Username,
-					Avatar:     car.Params.Avatar,
-					Categories: categories,
-					Points:     points,
-				}
-				categs[car.Params.CarID] = categories
-				pts[car.Params.CarID] = points
-				entries = append(entries, entry)
-			}
-		}
-
-		// Sort entries by total points
-		sort.Slice(entries, func(i, j int) bool {
-			sumI := 0
-			sumJ := 0
-			for _, p := range entries[i].Points {
-				sumI += p
-			}
-			for _, p := range entries[j].Points {
-				sumJ += p
-			}
-			return sumI > sumJ
-		})
-
-		// Set positions
-		for i := range entries {
-			entries[i].Position = i + 1
-			if prev, ok := a.Leaderboards[ageGroup]; ok {
-				// Find previous position for relative position calculation
-				for _, lastEntry := range prev {
-					a.UpdateLiveDataCarPositionCategory(lastEntry.CarID, categs[lastEntry.CarID], pts[lastEntry.CarID], entries[i].Position)
-					if lastEntry.CarID == entries[i].CarID {
-						entries[i].RelPos = lastEntry.Position - entries[i].Position
-						break
-					}
-				}
-			}
-		}
-
-		// Update leaderboard for this age group
-		if a.Leaderboards == nil {
-			a.Leaderboards = make(map[string][]LeaderboardEntry)
-		}
-		a.Leaderboards[ageGroup] = entries
-	}
-
-	return nil
-}
-
 func (a *AllData) GetLeaderboard(ageGroup string) ([]LeaderboardEntry, error) {
 	if a.Leaderboards == nil {
 		a.Leaderboards = make(map[string][]LeaderboardEntry)
 	}
+	if id, ok := a.ClassID(ageGroup); ok {
+		ageGroup = id
+	}
 	entries, ok := a.Leaderboards[ageGroup]
 	if !ok {
 		return nil, fmt.Errorf("no leaderboard found for age group %s", ageGroup)
@@ -401,6 +395,9 @@ func (a *AllData) DeleteLeaderboard(ageGroup string) error {
 	if a.Leaderboards == nil {
 		a.Leaderboards = make(map[string][]LeaderboardEntry)
 	}
+	if id, ok := a.ClassID(ageGroup); ok {
+		ageGroup = id
+	}
 	if _, ok := a.Leaderboards[ageGroup]; !ok {
 		return fmt.Errorf("no leaderboard found for age group %s", ageGroup)
 	}
@@ -410,38 +407,60 @@ func (a *AllData) DeleteLeaderboard(ageGroup string) error {
 		}
 		a.Leaderboards[ageGroup] = entries
 	}
+	// Relative positions are computed against the baseline, so start over from here
+	if a.Baselines == nil {
+		a.Baselines = make(map[string][]LeaderboardEntry)
+	}
+	a.Baselines[ageGroup] = append([]LeaderboardEntry{}, a.Leaderboards[ageGroup]...)
 	return nil
 }
 
-func (a *AllData) StartRace(s StartInstance) error {
+// CanStartRace checks that the car and race exist and the car is not racing already
+func (a *AllData) CanStartRace(s StartInstance) error {
 	car, ok := a.CarMap[s.CarID]
 	if !ok {
 		return fmt.Errorf("car with ID '%s' not found", s.CarID)
 	}
 
-	race, ok := a.Races[s.RaceName+"_"+strconv.Itoa(s.Lap)]
-	if !ok {
+	if _, ok := a.Races[s.RaceName+"_"+strconv.Itoa(s.Lap)]; !ok {
 		return fmt.Errorf("race '%s' not found", s.RaceName)
 	}
 
 	if car.CurrentRace != nil {
 		return fmt.Errorf("car '%s' is already in race '%s'", s.CarID, car.CurrentRace.RaceName)
 	}
+	return nil
+}
+
+func (a *AllData) StartRace(s StartInstance) error {
+	return a.StartRaceAt(s, time.Now())
+}
+
+// StartRaceAt starts the car's race timer at the given instant
+func (a *AllData) StartRaceAt(s StartInstance, start time.Time) error {
+	if err := a.CanStartRace(s); err != nil {
+		return err
+	}
+	car := a.CarMap[s.CarID]
+	race := a.Races[s.RaceName+"_"+strconv.Itoa(s.Lap)]
 
 	// Ensure RaceData map is initialized
 	if race.RaceData == nil {
 		race.RaceData = make(map[string]RaceData)
 	}
 
-	// Initialize race data for this car-+
+	// Initialize race data for this car-+, penalties already issued in the race are kept
 	race.RaceData[s.CarID] = RaceData{
-		Position: 0,
-		Points:   0,
-		TotalWh:  0,
-		RaceTime: 0,
-		Finished: false,
-		timer:    time.Now(),
-		RaceMode: true,
+		Position:  0,
+		Points:    0,
+		TotalWh:   0,
+		RaceTime:  0,
+		Finished:  false,
+		timer:     start,
+		RaceMode:  true,
+		StartedAt: start,
+		Stints:    []Stint{{Driver: car.Params.Username, Start: start}},
+		Penalties: race.RaceData[s.CarID].Penalties,
 	}
 
 	// Update car's current race
@@ -459,8 +478,11 @@ func (a *AllData) RaceFinish(r Race, srv *Service) error {
 	for carID, car := range a.CarMap {
 		if car.CurrentRace != nil && car.CurrentRace.RaceName == r.RaceName && car.CurrentRace.Lap == r.Lap {
 			if car.CurrentRace != nil && car.CurrentRace.RaceName == r.RaceName && car.CurrentRace.Lap == r.Lap {
-				// Get race data for this car
+				// Get race data for this car, a stop still open ends with the race
 				raceData := car.CurrentRace.RaceData[carID]
+				if raceData.InPit() {
+					car.CurrentRace.endPitStop(&raceData, time.Now())
+				}
 				raceData.FactualTime = time.Since(raceData.timer)
 				raceData.RaceMode = false
 				car.CurrentRace.RaceData[carID] = raceData
@@ -471,6 +493,19 @@ func (a *AllData) RaceFinish(r Race, srv *Service) error {
 			}
 		}
 	}
+
+	if err := a.UpdatePositions(raceKey(r)); err != nil {
+		return err
+	}
+	if err := a.ScoreRace(raceKey(r)); err != nil {
+		return err
+	}
+	// Positions moved by this race are shown against the leaderboard before it, also by later recomputes
+	a.SetLeaderboardBaselines()
+	if err := a.UpdateLeaderboard(); err != nil {
+		return err
+	}
+	a.TakeLeaderboardSnapshots(fmt.Sprintf("%s lap %d", r.RaceName, r.Lap))
 	return nil
 }
 
@@ -489,18 +524,22 @@ func (a *AllData) CarRaceFinish(s FinishInstance, srv *Service) error {
 		car.CurrentRace.RaceData = make(map[string]RaceData)
 	}
 
-	// Get race data for this car
+	// Get race data for this car, a stop still open ends with the race
 	raceData := car.CurrentRace.RaceData[s.CarID]
+	if raceData.InPit() {
+		car.CurrentRace.endPitStop(&raceData, time.Now())
+	}
 	raceData.FactualTime = time.Since(raceData.timer)
 	raceData.Finished = true
 	raceData.RaceMode = false
 	car.CurrentRace.RaceData[s.CarID] = raceData
+	key := raceKey(*car.CurrentRace)
 
 	// Clear car's current race
 	car.CurrentRace = nil
 	a.CarMap[s.CarID] = car
 
-	return nil
+	return a.UpdatePositions(key)
 }
 
 func (a *AllData) UpdatePoints(p Points) error {
@@ -516,12 +555,14 @@ func (a *AllData) UpdatePoints(p Points) error {
 			for _, pi := range p.Points {
 				if raceData, exists := race.RaceData[pi.CarID]; exists {
 					raceData.Points = pi.Points
+					raceData.PointsOverride = true
 					race.RaceData[pi.CarID] = raceData
 				} else {
 					// If car not found, create new RaceData entry
 					raceData = RaceData{
-						Position: 0,
-						Points:   pi.Points,
+						Position:       0,
+						Points:         pi.Points,
+						PointsOverride: true,
 					}
 					race.RaceData[pi.CarID] = raceData
 				}
@@ -540,8 +581,9 @@ func (a *AllData) UpdatePoints(p Points) error {
 		// Add points for each car
 		for _, pi := range p.Points {
 			newRace.RaceData[pi.CarID] = RaceData{
-				Position: 0,
-				Points:   pi.Points,
+				Position:       0,
+				Points:         pi.Points,
+				PointsOverride: true,
 			}
 		}
 		// Add new race to races map
@@ -562,9 +604,10 @@ func (a *AllData) ResetPoints(raceName string) error {
 			if race.RaceData == nil {
 				race.RaceData = make(map[string]RaceData)
 			}
-			// Reset points for all cars in the race
+			// Reset points for all cars in the race, dropping manual overrides
 			for carID, raceData := range race.RaceData {
-				raceData.Points = 0 // Set default point value to -1
+				raceData.Points = raceData.AutoPoints
+				raceData.PointsOverride = false
 				race.RaceData[carID] = raceData
 			}
 			a.Races[raceKey] = race
@@ -618,6 +661,20 @@ func (a *AllData) LoadFromFile() error {
 	if err != nil {
 		return fmt.Errorf("failed to unmarshal AllData: %w", err)
 	}
+	a.migrateScoringPenalties()
+
+	// Cars in a race point into the race map, restore that link after loading
+	for carID, car := range a.CarMap {
+		if car.CurrentRace == nil {
+			continue
+		}
+		if race, ok := a.Races[raceKey(*car.CurrentRace)]; ok {
+			car.CurrentRace = &race
+		} else {
+			car.CurrentRace = nil
+		}
+		a.CarMap[carID] = car
+	}
 
 	if a.UUID == (uuid.UUID{}) {
 		a.UUID = uuid.New()
@@ -636,6 +693,8 @@ func (a *AllData) ResetData() {
 	a.CarMap = make(map[string]Car)
 	a.Races = make(map[string]Race)
 	a.Leaderboards = make(map[string][]LeaderboardEntry)
+	a.LeaderboardSnapshots = make(map[string][]LeaderboardSnapshot)
+	a.Baselines = make(map[string][]LeaderboardEntry)
 }
 
 func (a *AllData) GetSettings() Settings {
@@ -644,55 +703,10 @@ func (a *AllData) GetSettings() Settings {
 
 func (a *AllData) UpdateSettings(settings Settings, srv *Service) {
 	a.Settings = settings
+	a.UpdateLeaderboard()
 
 	// Update PSU data for all registered cars based on new settings
-	for carID, car := range a.CarMap {
-		// Calculate max current
-		if car.Params.SetVoltage <= 0 {
-			logrus.Warnf("SetVoltage for car %s is zero or negative, skipping PSU update", carID)
-			continue
-		}
-		maxCurrent := car.Params.Mass * a.Settings.RaceCoeficient / car.Params.SetVoltage
-		car.Params.MaxCurrent = maxCurrent
-		payload := dataOutPSU{
-			U:      float32(car.Params.SetVoltage),
-			I:      float32(maxCurrent),
-			Status: 1,
-		}
-		srv.sendPSUData(carID, payload)
-	}
-}
-
-func (a *AllData) CheckSpeed(carID string, speed float32, srv *Service) {
-	if car, ok := a.CarMap[carID]; ok {
-		var over bool
-		if car.SpeedTestIterator > 6 && car.SpeedTestIterator <= 8 {
-			car.SpeedTestIterator++
-			return
-		} else if car.SpeedTestIterator >= 8 {
-			car.SpeedTestIterator = 0
-			payload := dataOutPSU{
-				U:      float32(car.Params.SetVoltage),
-				I:      float32(car.Params.MaxCurrent),
-				Status: 1,
-			}
-			srv.sendPSUData(carID, payload)
-		}
-		if over = (float64(speed) > a.Settings.MaxSpeed); over {
-			car.SpeedTestIterator++
-			a.CarMap[carID] = car
-		}
-		if car.SpeedTestIterator == 6 {
-			payload := dataOutPSU{
-				U:      float32(car.Params.SetVoltage),
-				I:      float32(car.Params.MaxCurrent),
-				Status: 0,
-			}
-			srv.sendPSUData(carID, payload)
-			car.SpeedTestIterator++
-			a.CarMap[carID] = car
-		}
-	}
+	srv.publishCarSetpoints()
 }
 
 func (a *AllData) AddCarToLiveData(carID string, username string, avatar string) {
@@ -705,6 +719,7 @@ func (a *AllData) AddCarToLiveData(carID string, username string, avatar string)
 	dat.CarID = carID
 	dat.Username = username
 	dat.Avatar = avatar
+	dat.EStop = a.EStop.Active
 	dat.UpdatedAt = time.Now()
 
 	a.LiveData[carID] = dat
@@ -714,7 +729,10 @@ func (a *AllData) UpdateLiveDataCarPositionCategory(carID string, Categories []s
 	a.LiveDataMutex.Lock()
 	defer a.LiveDataMutex.Unlock()
 
-	dat := a.LiveData[carID]
+	dat, ok := a.LiveData[carID]
+	if ok && dat.Position == Position && fmt.Sprint(dat.Categories) == fmt.Sprint(Categories) && fmt.Sprint(dat.Points) == fmt.Sprint(Points) {
+		return // UpdatedAt is only bumped on changes
+	}
 
 	dat.Categories = Categories
 	dat.Points = Points
@@ -724,6 +742,84 @@ func (a *AllData) UpdateLiveDataCarPositionCategory(carID string, Categories []s
 	a.LiveData[carID] = dat
 }
 
+func (a *AllData) UpdateLiveDataCarPenalties(carID string, penalties []Penalty) {
+	a.LiveDataMutex.Lock()
+	defer a.LiveDataMutex.Unlock()
+
+	dat := a.LiveData[carID]
+
+	dat.Penalties = penalties
+	dat.UpdatedAt = time.Now()
+
+	a.LiveData[carID] = dat
+}
+
+func (a *AllData) UpdateLiveDataCarPit(carID string, data RaceData) {
+	a.LiveDataMutex.Lock()
+	defer a.LiveDataMutex.Unlock()
+
+	dat := a.LiveData[carID]
+
+	dat.InPit = data.InPit()
+	dat.PitStops = len(data.PitStops)
+	dat.Driver = data.Driver()
+	dat.UpdatedAt = time.Now()
+
+	a.LiveData[carID] = dat
+}
+
+func (a *AllData) UpdateLiveDataCarPSUState(carID, state string, overLimit bool) {
+	a.LiveDataMutex.Lock()
+	defer a.LiveDataMutex.Unlock()
+
+	dat := a.LiveData[carID]
+
+	dat.PSUState = state
+	dat.OverLimit = overLimit
+	dat.UpdatedAt = time.Now()
+
+	a.LiveData[carID] = dat
+}
+
+func (a *AllData) UpdateLiveDataCarGovernor(carID, action string) {
+	a.LiveDataMutex.Lock()
+	defer a.LiveDataMutex.Unlock()
+
+	dat := a.LiveData[carID]
+
+	dat.Governor = action
+	dat.UpdatedAt = time.Now()
+
+	a.LiveData[carID] = dat
+}
+
+func (a *AllData) UpdateLiveDataCarTelemetry(carID, status string, stale []string) {
+	a.LiveDataMutex.Lock()
+	defer a.LiveDataMutex.Unlock()
+
+	dat, ok := a.LiveData[carID]
+	if ok && dat.Status == status && fmt.Sprint(dat.Stale) == fmt.Sprint(stale) {
+		return // UpdatedAt is only bumped on changes
+	}
+
+	dat.Status = status
+	dat.Stale = stale
+	dat.UpdatedAt = time.Now()
+
+	a.LiveData[carID] = dat
+}
+
+func (a *AllData) UpdateLiveDataEStop(active bool) {
+	a.LiveDataMutex.Lock()
+	defer a.LiveDataMutex.Unlock()
+
+	for carID, dat := range a.LiveData {
+		dat.EStop = active
+		dat.UpdatedAt = time.Now()
+		a.LiveData[carID] = dat
+	}
+}
+
 func (a *AllData) UpdateLiveDataCarGPS(carID string, lat, lon, speed float64) {
 	a.LiveDataMutex.Lock()
 	defer a.LiveDataMutex.Unlock()
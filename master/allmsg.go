@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 )
 
 func (a *AllData) MqttMessagePSU(carID string, power float64) (float64, error) {
@@ -52,16 +53,14 @@ func (a *AllData) MqttMessageAny(carID string) error {
 
 func (a *AllData) MqttMessageRST(carID string, porCode string, srv *Service) error {
 	if car, ok := a.CarMap[carID]; ok {
+		// The car lost its setpoint with the reset
+		if err := srv.republishSetpoint(carID); err != nil {
+			logrus.WithError(err).Error("Error")
+		}
 		race := car.CurrentRace
 		if race == nil {
 			return errors.New(fmt.Sprintf("CurrentRace is nil for car %s", carID))
 		}
-		payloadO := dataOutPSU{
-			U:      float32(car.Params.SetVoltage),
-			I:      float32(car.Params.MaxCurrent),
-			Status: 1,
-		}
-		srv.sendPSUData(carID, payloadO)
 		if raceData, exists := race.RaceData[carID]; exists {
 			raceData.timer = time.Now()
 
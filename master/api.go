@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	httprouter "github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
@@ -15,243 +19,1030 @@ func (srv *Service) getCars(w http.ResponseWriter, r *http.Request, ps httproute
 	logrus.Debugf("got getCars request %+v", ps)
 
 	cars := srv.AllData.GetCars()
+	for i := range cars {
+		if current, ok := srv.psuPub.limit(cars[i].CarID); ok {
+			cars[i].MaxCurrent = current
+		}
+	}
+
+	srv.AllData.SaveToFile()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(cars)
+}
+
+func (srv *Service) postCars(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/cars
+	logrus.Debugf("got postCars request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	var cars []Parameters
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+
+	if err := json.Unmarshal(body, &cars); err != nil {
+		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+		return
+	}
+
+	if err := srv.AllData.ValidateCars(cars); err != nil {
+		errorHandler(errors.Wrap(err, "Class"), http.StatusBadRequest)
+		return
+	}
+
+	srv.AllData.UpdateCars(cars, srv)
+
+	srv.AllData.SaveToFile()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (srv *Service) getClasses(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/classes
+	logrus.Debugf("got getClasses request %+v", ps)
+
+	classes := srv.AllData.GetClasses()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(classes)
+}
+
+func (srv *Service) postClasses(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/classes
+	logrus.Debugf("got postClasses request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	var classes []Class
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+
+	if err := json.Unmarshal(body, &classes); err != nil {
+		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+		return
+	}
+
+	if err := srv.AllData.UpdateClasses(classes); err != nil {
+		errorHandler(err, http.StatusBadRequest)
+		return
+	}
+
+	srv.AllData.SaveToFile()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (srv *Service) getTracks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/tracks
+	logrus.Debugf("got getTracks request %+v", ps)
+
+	tracks := srv.AllData.GetTracks()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tracks)
+}
+
+func (srv *Service) getTrack(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/tracks/:id
+	logrus.Debugf("got getTrack request %+v", ps)
+
+	track, err := srv.AllData.GetTrack(ps.ByName("id"))
+	if err != nil {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(track.GeoJSON())
+}
+
+func (srv *Service) postTracks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/tracks
+	logrus.Debugf("got postTracks request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	var tracks []Track
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+
+	if err := json.Unmarshal(body, &tracks); err != nil {
+		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+		return
+	}
+
+	if err := srv.AllData.UpdateTracks(tracks); err != nil {
+		errorHandler(err, http.StatusBadRequest)
+		return
+	}
+
+	srv.AllData.SaveToFile()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (srv *Service) getRaces(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/races
+	logrus.Debugf("got getRaces request %+v", ps)
+
+	races := srv.AllData.GetRaces()
+
+	srv.AllData.SaveToFile()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(races)
+}
+
+func (srv *Service) postRaces(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/races
+	logrus.Debugf("got postRaces request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+	var races []Race
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+
+	if err := json.Unmarshal(body, &races); err != nil {
+		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+		return
+	}
+	for _, race := range races {
+		if err := race.Scoring.Validate(); err != nil {
+			errorHandler(errors.Wrapf(err, "Race %s", race.RaceName), http.StatusBadRequest)
+			return
+		}
+		if err := srv.AllData.CheckRaceTrack(race); err != nil {
+			errorHandler(err, http.StatusBadRequest)
+			return
+		}
+		if err := validDistanceSource(race.DistanceSource); err != nil {
+			errorHandler(errors.Wrapf(err, "Race %s", race.RaceName), http.StatusBadRequest)
+			return
+		}
+		if err := race.Pit.Validate(); err != nil {
+			errorHandler(errors.Wrapf(err, "Race %s", race.RaceName), http.StatusBadRequest)
+			return
+		}
+	}
+
+	srv.AllData.UpdateRaces(races)
+
+	srv.AllData.SaveToFile()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (srv *Service) postScoreRace(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/races/:name/score
+	logrus.Debugf("got postScoreRace request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	err := srv.AllData.ScoreRaces(ps.ByName("name"))
+	if err != nil {
+		errorHandler(err, http.StatusNotFound)
+		return
+	}
+
+	srv.AllData.SaveToFile()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (srv *Service) getPenalties(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/races/:name/laps/:lap/penalties
+	logrus.Debugf("got getPenalties request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	lap, err := strconv.Atoi(ps.ByName("lap"))
+	if err != nil {
+		errorHandler(errors.Wrap(err, "Invalid lap"), http.StatusBadRequest)
+		return
+	}
+
+	penalties, err := srv.AllData.GetPenalties(ps.ByName("name"), lap)
+	if err != nil {
+		errorHandler(err, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(penalties)
+}
+
+func (srv *Service) postPenalty(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/races/:name/laps/:lap/penalties
+	logrus.Debugf("got postPenalty request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	lap, err := strconv.Atoi(ps.ByName("lap"))
+	if err != nil {
+		errorHandler(errors.Wrap(err, "Invalid lap"), http.StatusBadRequest)
+		return
+	}
+
+	var penalty Penalty
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &penalty); err != nil {
+		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+		return
+	}
+
+	penalty, err = srv.AllData.AddPenalty(ps.ByName("name"), lap, penalty)
+	if err != nil {
+		errorHandler(err, http.StatusBadRequest)
+		return
+	}
+
+	srv.AllData.SaveToFile()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(penalty)
+}
+
+func (srv *Service) deletePenalty(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // DELETE /api/races/:name/laps/:lap/penalties/:id?by=
+	logrus.Debugf("got deletePenalty request %+v, %+v", ps, r.URL.Query())
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	lap, err := strconv.Atoi(ps.ByName("lap"))
+	if err != nil {
+		errorHandler(errors.Wrap(err, "Invalid lap"), http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorHandler(errors.Wrap(err, "Invalid penalty ID"), http.StatusBadRequest)
+		return
+	}
+
+	penalty, err := srv.AllData.RevokePenalty(ps.ByName("name"), lap, id, r.URL.Query().Get("by"))
+	if err != nil {
+		errorHandler(err, http.StatusBadRequest)
+		return
+	}
+
+	srv.AllData.SaveToFile()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(penalty)
+}
+
+func (srv *Service) getResults(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/results/:racename
+	logrus.Debugf("got getResults request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	format, raceName := exportFormat(r, ps.ByName("racename"))
+
+	var laps []Race
+	for _, r := range srv.AllData.Races {
+		if r.RaceName == raceName {
+			laps = append(laps, r)
+		}
+	}
+	sort.Slice(laps, func(i, j int) bool { return laps[i].Lap < laps[j].Lap })
+
+	var results []Result
+	for _, lap := range laps {
+		res, err := srv.AllData.GetResults(raceKey(lap))
+		if err != nil {
+			errorHandler(err, http.StatusInternalServerError)
+			return
+		}
+		results = append(results, res...)
+	}
+
+	srv.AllData.SaveToFile()
+
+	if format != formatJSON {
+		if err := writeExport(w, format, raceName, resultsTable(results, exportLanguage(r))); err != nil {
+			logrus.WithError(errors.Wrap(err, "Export")).Error("Error")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(results)
+}
+
+func (srv *Service) getResultsSheet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/results/:racename/sheet
+	logrus.Debugf("got getResultsSheet request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	raceName := ps.ByName("racename")
+	lang := exportLanguage(r)
+
+	sheet, err := srv.AllData.GetResultsSheet(raceName)
+	if err != nil {
+		errorHandler(err, http.StatusNotFound)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "pdf" || strings.Contains(r.Header.Get("Accept"), "application/pdf") {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, raceName))
+		w.WriteHeader(http.StatusOK)
+		err = writeResultsSheetPDF(w, sheet, lang)
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		err = writeResultsSheetHTML(w, sheet, lang)
+	}
+	if err != nil {
+		logrus.WithError(errors.Wrap(err, "Sheet")).Error("Error")
+	}
+}
+
+func (srv *Service) getLeaderboard(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/leaderboard/:agegroup
+	logrus.Debugf("got getLeaderboard request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	format, ageGroup := exportFormat(r, ps.ByName("agegroup"))
+
+	leaderboard, err := srv.AllData.GetLeaderboard(ageGroup)
+	if err != nil {
+		errorHandler(err, http.StatusInternalServerError)
+		return
+	}
+
+	srv.AllData.SaveToFile()
+
+	if format != formatJSON {
+		if err := writeExport(w, format, "leaderboard_"+ageGroup, leaderboardTable(leaderboard, exportLanguage(r))); err != nil {
+			logrus.WithError(errors.Wrap(err, "Export")).Error("Error")
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(leaderboard)
+}
+
+func (srv *Service) getLeaderboardSnapshots(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/leaderboard/:agegroup/snapshots
+	logrus.Debugf("got getLeaderboardSnapshots request %+v", ps)
+
+	snapshots := srv.AllData.GetLeaderboardSnapshots(ps.ByName("agegroup"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(snapshots)
+}
+
+func (srv *Service) postLeaderboardSnapshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/leaderboard/:agegroup/snapshots
+	logrus.Debugf("got postLeaderboardSnapshot request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	var request struct {
+		Name string `json:"Name"`
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &request); err != nil {
+			errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	snapshot, err := srv.AllData.TakeLeaderboardSnapshot(ps.ByName("agegroup"), request.Name)
+	if err != nil {
+		errorHandler(err, http.StatusNotFound)
+		return
+	}
+
+	srv.AllData.SaveToFile()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func (srv *Service) getLeaderboardSnapshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/leaderboard/:agegroup/snapshots/:id
+	logrus.Debugf("got getLeaderboardSnapshot request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	id, err := strconv.Atoi(ps.ByName("id"))
+	if err != nil {
+		errorHandler(errors.Wrap(err, "Invalid snapshot ID"), http.StatusBadRequest)
+		return
+	}
+
+	snapshot, err := srv.AllData.GetLeaderboardSnapshot(ps.ByName("agegroup"), id)
+	if err != nil {
+		errorHandler(err, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+func (srv *Service) getLeaderboardDiff(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/leaderboard/:agegroup/diff?from=1&to=2
+	logrus.Debugf("got getLeaderboardDiff request %+v, %+v", ps, r.URL.Query())
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	from, err := strconv.Atoi(r.URL.Query().Get("from"))
+	if err != nil {
+		errorHandler(errors.Wrap(err, "Invalid 'from' snapshot ID"), http.StatusBadRequest)
+		return
+	}
+	var to int // compare against the current leaderboard when not given
+	if s := r.URL.Query().Get("to"); s != "" {
+		if to, err = strconv.Atoi(s); err != nil {
+			errorHandler(errors.Wrap(err, "Invalid 'to' snapshot ID"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	diff, err := srv.AllData.DiffLeaderboardSnapshots(ps.ByName("agegroup"), from, to)
+	if err != nil {
+		errorHandler(err, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(diff)
+}
+
+func (srv *Service) deleteLeaderboard(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // DELETE /api/leaderboard/:agegroup
+	logrus.Debugf("got deleteLeaderboard request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	ageGroup := ps.ByName("agegroup")
+
+	err := srv.AllData.DeleteLeaderboard(ageGroup)
+	if err != nil {
+		errorHandler(err, http.StatusInternalServerError)
+		return
+	}
+
+	srv.AllData.SaveToFile()
+
+	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(fmt.Sprintf("Leaderboard for age group '%s' deleted", ageGroup)))
+}
+
+func (srv *Service) postStartRace(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/race/start
+	logrus.Debugf("got postStartRace request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+	var start []StartInstance
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &start); err != nil {
+		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+		return
+	}
+
+	var errorS string
+	var errorB bool
+	for _, s := range start {
+		err = srv.AllData.StartRace(s)
+		if err != nil {
+			errorS += fmt.Sprintf("%s\n", err.Error())
+			errorB = true
+		}
+	}
+
+	srv.AllData.SaveToFile()
+
+	if errorB {
+		errorHandler(errors.New(errorS), http.StatusInternalServerError)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (srv *Service) getScheduledStart(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/race-control/start
+	logrus.Debugf("got getScheduledStart request %+v", ps)
+
+	start, ok := srv.GetScheduledStart()
+	if !ok {
+		http.Error(w, "no start is scheduled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(start)
+}
+
+func (srv *Service) postScheduleStart(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/race-control/start
+	logrus.Debugf("got postScheduleStart request %+v", ps)
+
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+	var cmd StartCommand
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &cmd); err != nil {
+		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+		return
+	}
+
+	start, err := srv.ScheduleStart(cmd)
+	if err != nil {
+		errorHandler(err, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(start)
+}
+
+func (srv *Service) deleteScheduledStart(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // DELETE /api/race-control/start
+	logrus.Debugf("got deleteScheduledStart request %+v", ps)
+
+	start, err := srv.AbortStart()
+	if err != nil {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(start)
+}
+
+// EStopRequest says who set or released the emergency stop and why
+type EStopRequest struct {
+	By     string `json:"By"`
+	Reason string `json:"Reason"`
+}
+
+func (srv *Service) getEStop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/race-control/estop
+	logrus.Debugf("got getEStop request %+v", ps)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(srv.GetEStop())
+}
+
+func (srv *Service) postEStop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/race-control/estop
+	logrus.Debugf("got postEStop request %+v", ps)
 
+	// The body is optional, an emergency stop must never be refused
+	var req EStopRequest
+	if body, err := io.ReadAll(r.Body); err == nil && len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			logrus.WithError(errors.Wrap(err, "Unmarshal")).Warn("Emergency stop without details")
+		}
+	}
+
+	estop := srv.EmergencyStop(req.By, req.Reason)
 	srv.AllData.SaveToFile()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(cars)
+	json.NewEncoder(w).Encode(estop)
 }
 
-func (srv *Service) postCars(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/cars
-	logrus.Debugf("got postCars request %+v", ps)
+func (srv *Service) postReleaseEStop(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/race-control/release
+	logrus.Debugf("got postReleaseEStop request %+v", ps)
 
 	errorHandler := func(err error, code int) {
 		logrus.WithError(err).Error("Error")
 		http.Error(w, err.Error(), code)
 	}
 
-	var cars []Parameters
+	var req EStopRequest
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
 		return
 	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+			return
+		}
+	}
 
-	if err := json.Unmarshal(body, &cars); err != nil {
-		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+	estop, err := srv.ReleaseEStop(req.By)
+	if err != nil {
+		errorHandler(err, http.StatusConflict)
 		return
 	}
-
-	srv.AllData.UpdateCars(cars, srv)
-
 	srv.AllData.SaveToFile()
 
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(estop)
 }
 
-func (srv *Service) getRaces(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/races
-	logrus.Debugf("got getRaces request %+v", ps)
+func (srv *Service) postRaceFinish(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/race/finish
+	logrus.Debugf("got postRaceFinish request %+v", ps)
 
-	races := srv.AllData.GetRaces()
+	errorHandler := func(err error, code int) {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), code)
+	}
+
+	var finish Race
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+	if err := json.Unmarshal(body, &finish); err != nil {
+		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+		return
+	}
+
+	err = srv.AllData.RaceFinish(finish, srv)
+	if err != nil {
+		errorHandler(err, http.StatusInternalServerError)
+		return
+	}
 
 	srv.AllData.SaveToFile()
 
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(races)
 }
 
-func (srv *Service) postRaces(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/races
-	logrus.Debugf("got postRaces request %+v", ps)
+func (srv *Service) postCarFinish(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/car/finish
+	logrus.Debugf("got postCarFinish request %+v", ps)
 
 	errorHandler := func(err error, code int) {
 		logrus.WithError(err).Error("Error")
 		http.Error(w, err.Error(), code)
 	}
-	var races []Race
+
+	var finish FinishInstance
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
 		return
 	}
-
-	if err := json.Unmarshal(body, &races); err != nil {
+	if err := json.Unmarshal(body, &finish); err != nil {
 		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
 		return
 	}
 
-	srv.AllData.UpdateRaces(races)
+	err = srv.AllData.CarRaceFinish(finish, srv)
+	if err != nil {
+		errorHandler(err, http.StatusInternalServerError)
+		return
+	}
 
 	srv.AllData.SaveToFile()
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func (srv *Service) getResults(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/results/:racename
-	logrus.Debugf("got getResults request %+v", ps)
+func (srv *Service) postPitIn(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/cars/:id/pit/in
+	logrus.Debugf("got postPitIn request %+v", ps)
+	srv.handlePit(w, r, ps, true)
+}
 
+func (srv *Service) postPitOut(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/cars/:id/pit/out
+	logrus.Debugf("got postPitOut request %+v", ps)
+	srv.handlePit(w, r, ps, false)
+}
+
+func (srv *Service) handlePit(w http.ResponseWriter, r *http.Request, ps httprouter.Params, in bool) {
 	errorHandler := func(err error, code int) {
 		logrus.WithError(err).Error("Error")
 		http.Error(w, err.Error(), code)
 	}
 
-	raceName := ps.ByName("racename")
-
-	var results []Result
-	for s, r := range srv.AllData.Races {
-		if r.RaceName == raceName {
-			res, err := srv.AllData.GetResults(s)
-			if err != nil {
-				errorHandler(err, http.StatusInternalServerError)
-				return
-			}
-			results = append(results, res...)
+	// The body is optional, it only names the driver taking over
+	var pit PitInstance
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &pit); err != nil {
+			errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
+			return
 		}
 	}
 
+	if in {
+		err = srv.AllData.PitIn(ps.ByName("id"), pit, PitSourceAPI, time.Now())
+	} else {
+		err = srv.AllData.PitOut(ps.ByName("id"), pit, time.Now())
+	}
+	if err != nil {
+		errorHandler(err, http.StatusBadRequest)
+		return
+	}
+
 	srv.AllData.SaveToFile()
 
-	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(results)
 }
 
-func (srv *Service) getLeaderboard(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/leaderboard/:agegroup
-	logrus.Debugf("got getLeaderboard request %+v", ps)
+func (srv *Service) getPSUState(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/cars/:id/psu
+	logrus.Debugf("got getPSUState request %+v", ps)
 
-	errorHandler := func(err error, code int) {
+	state, err := srv.GetPSUState(ps.ByName("id"))
+	if err != nil {
 		logrus.WithError(err).Error("Error")
-		http.Error(w, err.Error(), code)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
 
-	ageGroup := ps.ByName("agegroup")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(state)
+}
 
-	leaderboard, err := srv.AllData.GetLeaderboard(ageGroup)
+func (srv *Service) getCarLimits(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/cars/:id/limits
+	logrus.Debugf("got getCarLimits request %+v", ps)
+
+	preview, err := srv.PreviewLimits(ps.ByName("id"))
 	if err != nil {
-		errorHandler(err, http.StatusInternalServerError)
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	srv.AllData.SaveToFile()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(preview)
+}
+
+func (srv *Service) getCommands(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/cars/:id/commands
+	logrus.Debugf("got getCommands request %+v", ps)
+
+	commands := srv.GetCommands(ps.ByName("id"))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(leaderboard)
+	json.NewEncoder(w).Encode(commands)
 }
 
-func (srv *Service) deleteLeaderboard(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // DELETE /api/leaderboard/:agegroup
-	logrus.Debugf("got deleteLeaderboard request %+v", ps)
+func (srv *Service) getCommand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/cars/:id/commands/:cmd
+	logrus.Debugf("got getCommand request %+v", ps)
 
 	errorHandler := func(err error, code int) {
 		logrus.WithError(err).Error("Error")
 		http.Error(w, err.Error(), code)
 	}
 
-	ageGroup := ps.ByName("agegroup")
-
-	err := srv.AllData.DeleteLeaderboard(ageGroup)
+	id, err := strconv.Atoi(ps.ByName("cmd"))
 	if err != nil {
-		errorHandler(err, http.StatusInternalServerError)
+		errorHandler(errors.Wrap(err, "Atoi"), http.StatusBadRequest)
+		return
+	}
+	command, err := srv.GetCommand(ps.ByName("id"), id)
+	if err != nil {
+		errorHandler(err, http.StatusNotFound)
 		return
 	}
 
-	srv.AllData.SaveToFile()
-
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte(fmt.Sprintf("Leaderboard for age group '%s' deleted", ageGroup)))
+	json.NewEncoder(w).Encode(command)
 }
 
-func (srv *Service) postStartRace(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/race/start
-	logrus.Debugf("got postStartRace request %+v", ps)
+func (srv *Service) postCommand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/cars/:id/commands
+	logrus.Debugf("got postCommand request %+v", ps)
 
 	errorHandler := func(err error, code int) {
 		logrus.WithError(err).Error("Error")
 		http.Error(w, err.Error(), code)
 	}
-	var start []StartInstance
+
+	var command Command
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
 		return
 	}
-	if err := json.Unmarshal(body, &start); err != nil {
+	if err := json.Unmarshal(body, &command); err != nil {
 		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
 		return
 	}
 
-	var errorS string
-	var errorB bool
-	for _, s := range start {
-		err = srv.AllData.StartRace(s)
-		if err != nil {
-			errorS += fmt.Sprintf("%s\n", err.Error())
-			errorB = true
-		}
+	carID := ps.ByName("id")
+	if _, ok := srv.AllData.CarMap[carID]; !ok {
+		errorHandler(fmt.Errorf("car with ID '%s' not found", carID), http.StatusNotFound)
+		return
+	}
+	command, err = srv.SendCommand(carID, command)
+	if err != nil {
+		errorHandler(err, http.StatusBadRequest)
+		return
 	}
 
-	srv.AllData.SaveToFile()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(command)
+}
 
-	if errorB {
-		errorHandler(errors.New(errorS), http.StatusInternalServerError)
-	} else {
-		w.WriteHeader(http.StatusOK)
+func (srv *Service) getCarTelemetry(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/cars/:id/telemetry
+	logrus.Debugf("got getCarTelemetry request %+v", ps)
+
+	telemetry, err := srv.GetCarTelemetry(ps.ByName("id"))
+	if err != nil {
+		logrus.WithError(err).Error("Error")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(telemetry)
 }
 
-func (srv *Service) postRaceFinish(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/race/finish
-	logrus.Debugf("got postRaceFinish request %+v", ps)
+func (srv *Service) getTelemetry(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/telemetry
+	logrus.Debugf("got getTelemetry request %+v", ps)
+
+	telemetry := srv.GetTelemetry()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(telemetry)
+}
+
+func (srv *Service) getTelemetryEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/telemetry/events?car=ID
+	logrus.Debugf("got getTelemetryEvents request %+v, %+v", ps, r.URL.Query())
+
+	events := srv.GetTelemetryEvents(r.URL.Query().Get("car"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(events)
+}
+
+func (srv *Service) getAlerts(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/alerts
+	logrus.Debugf("got getAlerts request %+v", ps)
+
+	alerts := srv.GetAlerts()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(alerts)
+}
+
+func (srv *Service) getAlertHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/alerts/history?car=ID
+	logrus.Debugf("got getAlertHistory request %+v, %+v", ps, r.URL.Query())
+
+	alerts := srv.GetAlertHistory(r.URL.Query().Get("car"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// AlertAck acknowledges an active alert
+type AlertAck struct {
+	ID int    `json:"ID"`
+	By string `json:"By"`
+}
+
+func (srv *Service) postAckAlert(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/alerts/ack
+	logrus.Debugf("got postAckAlert request %+v", ps)
 
 	errorHandler := func(err error, code int) {
 		logrus.WithError(err).Error("Error")
 		http.Error(w, err.Error(), code)
 	}
 
-	var finish Race
+	var ack AlertAck
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
 		return
 	}
-	if err := json.Unmarshal(body, &finish); err != nil {
+	if err := json.Unmarshal(body, &ack); err != nil {
 		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
 		return
 	}
 
-	err = srv.AllData.RaceFinish(finish, srv)
+	alert, err := srv.AckAlert(ack.ID, ack.By)
 	if err != nil {
-		errorHandler(err, http.StatusInternalServerError)
+		errorHandler(err, http.StatusNotFound)
 		return
 	}
 
-	srv.AllData.SaveToFile()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(alert)
+}
+
+func (srv *Service) getAlertRules(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/alerts/rules
+	logrus.Debugf("got getAlertRules request %+v", ps)
 
+	rules := srv.AllData.GetAlertRules()
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(rules)
 }
 
-func (srv *Service) postCarFinish(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/car/finish
-	logrus.Debugf("got postCarFinish request %+v", ps)
+func (srv *Service) postAlertRules(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/alerts/rules
+	logrus.Debugf("got postAlertRules request %+v", ps)
 
 	errorHandler := func(err error, code int) {
 		logrus.WithError(err).Error("Error")
 		http.Error(w, err.Error(), code)
 	}
 
-	var finish FinishInstance
+	var rules []AlertRule
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		errorHandler(errors.Wrap(err, "ReadAll"), http.StatusBadRequest)
 		return
 	}
-	if err := json.Unmarshal(body, &finish); err != nil {
+	if err := json.Unmarshal(body, &rules); err != nil {
 		errorHandler(errors.Wrap(err, "Unmarshal"), http.StatusBadRequest)
 		return
 	}
 
-	err = srv.AllData.CarRaceFinish(finish, srv)
-	if err != nil {
-		errorHandler(err, http.StatusInternalServerError)
+	if err := srv.UpdateAlertRules(rules); err != nil {
+		errorHandler(err, http.StatusBadRequest)
 		return
 	}
 
@@ -260,6 +1051,36 @@ func (srv *Service) postCarFinish(w http.ResponseWriter, r *http.Request, ps htt
 	w.WriteHeader(http.StatusOK)
 }
 
+func (srv *Service) getPSUStates(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/psu
+	logrus.Debugf("got getPSUStates request %+v", ps)
+
+	states := srv.GetPSUStates()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(states)
+}
+
+func (srv *Service) getGovernorStates(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/race-control/governor
+	logrus.Debugf("got getGovernorStates request %+v", ps)
+
+	states := srv.GetGovernorStates()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(states)
+}
+
+func (srv *Service) getGovernorEvents(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // GET /api/race-control/governor/events?car=ID
+	logrus.Debugf("got getGovernorEvents request %+v, %+v", ps, r.URL.Query())
+
+	events := srv.GetGovernorEvents(r.URL.Query().Get("car"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(events)
+}
+
 func (srv *Service) postPoints(w http.ResponseWriter, r *http.Request, ps httprouter.Params) { // POST /api/points
 	logrus.Debugf("got postPoints request %+v", ps)
 
@@ -367,6 +1188,31 @@ func (srv *Service) postSettings(w http.ResponseWriter, r *http.Request, ps http
 		return
 	}
 
+	if err := settings.Limits.Validate(); err != nil {
+		errorHandler(errors.Wrap(err, "Limits"), http.StatusBadRequest)
+		return
+	}
+	if err := settings.Governor.Validate(settings.MaxSpeed); err != nil {
+		errorHandler(errors.Wrap(err, "Governor"), http.StatusBadRequest)
+		return
+	}
+	if settings.MaxSpeed < 0 {
+		errorHandler(errors.New("MaxSpd must not be negative"), http.StatusBadRequest)
+		return
+	}
+	if err := validLiveRate(settings.LiveRate); err != nil {
+		errorHandler(err, http.StatusBadRequest)
+		return
+	}
+	if err := settings.Telemetry.Validate(); err != nil {
+		errorHandler(errors.Wrap(err, "Telemetry"), http.StatusBadRequest)
+		return
+	}
+	if err := settings.Championship.Validate(); err != nil {
+		errorHandler(errors.Wrap(err, "Championship"), http.StatusBadRequest)
+		return
+	}
+
 	srv.AllData.UpdateSettings(settings, srv)
 
 	srv.AllData.SaveToFile()
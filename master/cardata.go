@@ -214,6 +214,6 @@ func (table *CarIDMap) SendMessagePSU(carID string, srv *Service) {
 	}
 	if car, ok := (*table)[carID]; ok {
 		payload := dataOutPSU{U: float32(car.Params.SetVoltage), I: float32(car.Params.MaxCurrent)}
-		srv.sendPSUData(carID, payload)
+		srv.publishSetpoint(carID, payload)
 	}
 }
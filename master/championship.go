@@ -0,0 +1,81 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ChampionshipRules decide which category results count toward the championship total
+type ChampionshipRules struct {
+	Weights   map[string]float64 `json:"Weights"`   // points multiplier by category (race name), 1 when not given
+	BestOf    int                `json:"BestOf"`    // count only the best N categories, 0 counts all of them
+	DropWorst int                `json:"DropWorst"` // number of lowest category results left out
+	Mandatory []string           `json:"Mandatory"` // categories that always count and are never dropped
+}
+
+func (rules ChampionshipRules) Validate() error {
+	for category, weight := range rules.Weights {
+		if weight < 0 {
+			return fmt.Errorf("weight of category '%s' must not be negative", category)
+		}
+	}
+	if rules.BestOf < 0 || rules.DropWorst < 0 {
+		return fmt.Errorf("BestOf and DropWorst must not be negative")
+	}
+	return nil
+}
+
+func (rules ChampionshipRules) weight(category string) float64 {
+	if w, ok := rules.Weights[category]; ok {
+		return w
+	}
+	return 1
+}
+
+func (rules ChampionshipRules) mandatory(category string) bool {
+	for _, m := range rules.Mandatory {
+		if m == category {
+			return true
+		}
+	}
+	return false
+}
+
+// apply computes the counted total of the entry from its category points and lists the dropped categories
+func (rules ChampionshipRules) apply(entry *LeaderboardEntry) {
+	type result struct {
+		category string
+		points   float64
+	}
+	var optional []result
+	entry.Counted = 0
+	entry.Dropped = nil
+	for i, category := range entry.Categories {
+		points := float64(entry.Points[i]) * rules.weight(category)
+		if rules.mandatory(category) {
+			entry.Counted += points
+		} else {
+			optional = append(optional, result{category, points})
+		}
+	}
+
+	drop := rules.DropWorst
+	if rules.BestOf > 0 && len(entry.Categories)-rules.BestOf > drop {
+		drop = len(entry.Categories) - rules.BestOf
+	}
+	if drop > len(optional) {
+		drop = len(optional)
+	}
+
+	// Worst first; equal results are dropped in category order to stay deterministic
+	sort.SliceStable(optional, func(i, j int) bool {
+		return optional[i].points < optional[j].points
+	})
+	for i, r := range optional {
+		if i < drop {
+			entry.Dropped = append(entry.Dropped, r.category)
+		} else {
+			entry.Counted += r.points
+		}
+	}
+}
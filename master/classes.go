@@ -0,0 +1,146 @@
+package master
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Class is an age group or competition class. Cars refer to it by ID in Parameters.AgeGroup.
+type Class struct {
+	ID          string      `json:"id"`
+	DisplayName string      `json:"name"`
+	MinMass     float64     `json:"minMass"`    // kg, 0 for no lower limit
+	MaxMass     float64     `json:"maxMass"`    // kg, 0 for no upper limit
+	MaxVoltage  float64     `json:"maxVoltage"` // V, 0 for no limit
+	Races       []string    `json:"races"`      // race names that count toward the class leaderboard, empty for all
+	Limits      *LimitRules `json:"limits"`     // PSU limit rules replacing the settings' ones, nil to use those
+}
+
+func (c Class) Validate() error {
+	if strings.TrimSpace(c.ID) == "" {
+		return fmt.Errorf("class ID must not be empty")
+	}
+	if strings.EqualFold(c.ID, overallLeaderboard) {
+		return fmt.Errorf("class ID '%s' is reserved for the overall leaderboard", c.ID)
+	}
+	if c.MinMass < 0 || c.MaxMass < 0 || c.MaxVoltage < 0 {
+		return fmt.Errorf("class '%s' limits must not be negative", c.ID)
+	}
+	if c.MaxMass > 0 && c.MinMass > c.MaxMass {
+		return fmt.Errorf("class '%s' minimum mass is above maximum mass", c.ID)
+	}
+	if c.Limits != nil {
+		if err := c.Limits.Validate(); err != nil {
+			return fmt.Errorf("class '%s' limits: %s", c.ID, err.Error())
+		}
+	}
+	return nil
+}
+
+// Eligible checks that the car parameters are within the class limits
+func (c Class) Eligible(p Parameters) error {
+	if c.MinMass > 0 && p.Mass < c.MinMass {
+		return fmt.Errorf("car '%s' mass %.1f kg is below the class '%s' minimum of %.1f kg", p.CarID, p.Mass, c.ID, c.MinMass)
+	}
+	if c.MaxMass > 0 && p.Mass > c.MaxMass {
+		return fmt.Errorf("car '%s' mass %.1f kg is above the class '%s' maximum of %.1f kg", p.CarID, p.Mass, c.ID, c.MaxMass)
+	}
+	if c.MaxVoltage > 0 && p.SetVoltage > c.MaxVoltage {
+		return fmt.Errorf("car '%s' voltage %.2f V is above the class '%s' cap of %.2f V", p.CarID, p.SetVoltage, c.ID, c.MaxVoltage)
+	}
+	return nil
+}
+
+// CountsRace tells if the race counts toward the class leaderboard
+func (c Class) CountsRace(raceName string) bool {
+	if len(c.Races) == 0 {
+		return true
+	}
+	for _, r := range c.Races {
+		if r == raceName {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *AllData) GetClasses() []Class {
+	classes := make([]Class, 0, len(a.Classes))
+	for _, c := range a.Classes {
+		classes = append(classes, c)
+	}
+	return classes
+}
+
+func (a *AllData) UpdateClasses(classes []Class) error {
+	updated := make(map[string]Class, len(classes))
+	for _, c := range classes {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		for id := range updated {
+			if strings.EqualFold(id, c.ID) {
+				return fmt.Errorf("class '%s' is defined more than once", c.ID)
+			}
+		}
+		updated[c.ID] = c
+	}
+	a.Classes = updated
+
+	// Report cars that no longer fit, they are rejected on their next registration
+	for carID, car := range a.CarMap {
+		if _, err := a.CheckClass(car.Params); err != nil {
+			logrus.Warnf("Car %s: %s", carID, err.Error())
+		}
+	}
+	return a.UpdateLeaderboard()
+}
+
+// ClassID resolves a class name case-insensitively to its defined ID
+func (a *AllData) ClassID(name string) (string, bool) {
+	if _, ok := a.Classes[name]; ok {
+		return name, true
+	}
+	for id := range a.Classes {
+		if strings.EqualFold(id, strings.TrimSpace(name)) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// CheckClass returns the class ID the car belongs to and an error when it is unknown or the
+// car is not eligible. Without any classes defined the age group is taken as is.
+func (a *AllData) CheckClass(p Parameters) (string, error) {
+	if len(a.Classes) == 0 {
+		return p.AgeGroup, nil
+	}
+	id, ok := a.ClassID(p.AgeGroup)
+	if !ok {
+		return "", fmt.Errorf("car '%s' class '%s' is not defined", p.CarID, p.AgeGroup)
+	}
+	return id, a.Classes[id].Eligible(p)
+}
+
+// ValidateCars checks every car against its class and normalizes the class name to the defined ID
+func (a *AllData) ValidateCars(cars []Parameters) error {
+	var errs []string
+	for i := range cars {
+		if err := validGPSFormat(cars[i].GPSFormat); err != nil {
+			errs = append(errs, fmt.Sprintf("car '%s': %s", cars[i].CarID, err.Error()))
+			continue
+		}
+		id, err := a.CheckClass(cars[i])
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		cars[i].AgeGroup = id
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,354 @@
+package master
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Command types. The PSU commands change the car's retained PSU_IN setpoint and are acknowledged by
+// PSU_OUT readings that comply with it, the others are sent on CMD_IN/<carID> and acknowledged on CMD_OUT/<carID>.
+const (
+	CommandEnablePSU  = "enable_psu"
+	CommandDisablePSU = "disable_psu"
+	CommandSetLimits  = "set_limits" // U and I replace the computed limits, both 0 to go back to them
+	CommandReboot     = "reboot"
+	CommandStatus     = "status" // the car replies with its status in the acknowledgement
+)
+
+// Command states
+const (
+	CommandQueued  = "queued"
+	CommandSent    = "sent"
+	CommandAcked   = "acked"
+	CommandFailed  = "failed" // the car reported an error
+	CommandTimeout = "timeout"
+)
+
+const (
+	commandTimeout      = 10 * time.Second // to acknowledge a sent command
+	commandQueueTimeout = 60 * time.Second // to get a queued command sent
+	commandHistory      = 100              // commands kept per car
+)
+
+type Command struct {
+	ID        int             `json:"ID"`
+	CarID     string          `json:"CarID"`
+	Type      string          `json:"Type"`
+	U         float64         `json:"U,omitempty"` // V, set_limits
+	I         float64         `json:"I,omitempty"` // A, set_limits
+	Status    string          `json:"Status"`
+	Error     string          `json:"Error,omitempty"`
+	Reply     json.RawMessage `json:"Reply,omitempty"` // data the car sent with the acknowledgement
+	CreatedAt time.Time       `json:"Created at"`
+	SentAt    time.Time       `json:"Sent at"`
+	DoneAt    time.Time       `json:"Done at"`
+}
+
+// commandOut is published on CMD_IN/<carID>
+type commandOut struct {
+	ID  int    `json:"id"`
+	Cmd string `json:"cmd"`
+}
+
+// commandAck is received on CMD_OUT/<carID>
+type commandAck struct {
+	ID    int             `json:"id"`
+	OK    bool            `json:"ok"`
+	Error string          `json:"error"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// psuOverride is what the PSU commands left in force for a car
+type psuOverride struct {
+	Off  bool
+	U, I float64 // 0 to keep the computed limit
+}
+
+type commandQueue struct {
+	mutex     sync.Mutex
+	seq       int
+	history   map[string][]*Command // map of [carID], oldest first
+	overrides map[string]psuOverride
+}
+
+func (c Command) Validate() error {
+	switch c.Type {
+	case CommandEnablePSU, CommandDisablePSU, CommandReboot, CommandStatus:
+	case CommandSetLimits:
+		if c.U < 0 || c.I < 0 {
+			return fmt.Errorf("limits must not be negative")
+		}
+		if c.I > psuHardwareMaxCurrent {
+			return fmt.Errorf("current %.2f A is above the PSU maximum of %d A", c.I, psuHardwareMaxCurrent)
+		}
+	default:
+		return fmt.Errorf("unknown command type '%s'", c.Type)
+	}
+	return nil
+}
+
+// checkLimitsOverride holds limits set by a command to the caps ComputeLimits applies to the car,
+// the override replaces the computed limits so they are not capped again
+func (a *AllData) checkLimitsOverride(carID string, u, i float64) error {
+	car := a.CarMap[carID]
+	rules, class := a.carRules(car)
+	if u > car.Params.SetVoltage {
+		return fmt.Errorf("voltage %.2f V is above the car's set voltage of %.2f V", u, car.Params.SetVoltage)
+	}
+	if rules.MaxVoltage > 0 && u > rules.MaxVoltage {
+		return fmt.Errorf("voltage %.2f V is above the maximum of %.2f V", u, rules.MaxVoltage)
+	}
+	if class != nil && class.MaxVoltage > 0 && u > class.MaxVoltage {
+		return fmt.Errorf("voltage %.2f V is above the class %s maximum of %.2f V", u, class.ID, class.MaxVoltage)
+	}
+	if rules.MaxCurrent > 0 && i > rules.MaxCurrent {
+		return fmt.Errorf("current %.2f A is above the maximum of %.2f A", i, rules.MaxCurrent)
+	}
+	if rules.MaxPower > 0 && u*i > rules.MaxPower {
+		return fmt.Errorf("power %.2f W is above the maximum of %.2f W", u*i, rules.MaxPower)
+	}
+	return nil
+}
+
+func (c Command) psu() bool {
+	return c.Type == CommandEnablePSU || c.Type == CommandDisablePSU || c.Type == CommandSetLimits
+}
+
+func (c Command) done() bool {
+	return c.Status != CommandQueued && c.Status != CommandSent
+}
+
+func (q *commandQueue) add(c *Command) {
+	if q.history == nil {
+		q.history = make(map[string][]*Command)
+	}
+	q.seq++
+	c.ID = q.seq
+	h := append(q.history[c.CarID], c)
+	if len(h) > commandHistory {
+		h = h[len(h)-commandHistory:]
+	}
+	q.history[c.CarID] = h
+}
+
+// override returns the PSU override in force for the car
+func (q *commandQueue) override(carID string) psuOverride {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.overrides[carID]
+}
+
+func (q *commandQueue) remove(carID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.overrides, carID)
+}
+
+// SendCommand queues a command for the car and sends it right away when possible
+func (srv *Service) SendCommand(carID string, c Command) (Command, error) {
+	if _, ok := srv.AllData.CarMap[carID]; !ok {
+		return Command{}, fmt.Errorf("car with ID '%s' not found", carID)
+	}
+	if err := c.Validate(); err != nil {
+		return Command{}, err
+	}
+	if c.Type == CommandSetLimits {
+		if err := srv.AllData.checkLimitsOverride(carID, c.U, c.I); err != nil {
+			return Command{}, err
+		}
+	}
+	c.CarID, c.Status, c.CreatedAt = carID, CommandQueued, time.Now()
+	c.Error, c.Reply, c.SentAt, c.DoneAt = "", nil, time.Time{}, time.Time{}
+
+	srv.commands.mutex.Lock()
+	cmd := &c
+	srv.commands.add(cmd)
+	if cmd.psu() {
+		if srv.commands.overrides == nil {
+			srv.commands.overrides = make(map[string]psuOverride)
+		}
+		o := srv.commands.overrides[carID]
+		switch cmd.Type {
+		case CommandEnablePSU:
+			o.Off = false
+		case CommandDisablePSU:
+			o.Off = true
+		case CommandSetLimits:
+			o.U, o.I = cmd.U, cmd.I
+		}
+		srv.commands.overrides[carID] = o
+	}
+	srv.commands.mutex.Unlock()
+
+	logrus.Infof("Command %d %s queued for car %s", cmd.ID, cmd.Type, carID)
+	if cmd.psu() {
+		if err := srv.publishCarSetpoint(carID); err != nil {
+			logrus.WithError(err).Errorf("PSU setpoint for car %s", carID)
+		}
+	}
+	srv.processCommands()
+	return srv.GetCommand(carID, cmd.ID)
+}
+
+func (srv *Service) GetCommands(carID string) []Command {
+	srv.commands.mutex.Lock()
+	defer srv.commands.mutex.Unlock()
+	commands := []Command{}
+	for _, c := range srv.commands.history[carID] {
+		commands = append(commands, *c)
+	}
+	return commands
+}
+
+func (srv *Service) GetCommand(carID string, id int) (Command, error) {
+	srv.commands.mutex.Lock()
+	defer srv.commands.mutex.Unlock()
+	for _, c := range srv.commands.history[carID] {
+		if c.ID == id {
+			return *c, nil
+		}
+	}
+	return Command{}, fmt.Errorf("command %d for car '%s' not found", id, carID)
+}
+
+// processCommands sends queued commands and times out those that were not acknowledged
+func (srv *Service) processCommands() {
+	var finished []Command
+	srv.commands.mutex.Lock()
+	now := time.Now()
+	for carID, history := range srv.commands.history {
+		for _, c := range history {
+			switch {
+			case c.done():
+				continue
+			case c.Status == CommandQueued && now.Sub(c.CreatedAt) >= commandQueueTimeout,
+				c.Status == CommandSent && now.Sub(c.SentAt) >= commandTimeout:
+				c.Status, c.DoneAt = CommandTimeout, now
+				finished = append(finished, *c)
+			case c.Status != CommandQueued:
+				continue
+			case c.psu():
+				// Sent once the publisher has no setpoint held back for the car
+				if srv.psuPub.settled(carID) {
+					c.Status, c.SentAt, c.Error = CommandSent, now, ""
+				}
+			default:
+				if err := srv.publishCommand(*c); err != nil {
+					c.Error = err.Error() // retried on the next tick
+					continue
+				}
+				c.Status, c.SentAt, c.Error = CommandSent, now, ""
+			}
+		}
+	}
+	srv.commands.mutex.Unlock()
+	for _, c := range finished {
+		srv.commandDone(c)
+	}
+}
+
+func (srv *Service) publishCommand(c Command) error {
+	if srv.mqtt == nil {
+		return errors.New("MQTT client not connected")
+	}
+	bytes, err := json.Marshal(commandOut{ID: c.ID, Cmd: c.Type})
+	if err != nil {
+		return errors.Wrap(err, "JSON")
+	}
+	return srv.sendAnyTopic(fmt.Sprintf("CMD_IN/%s", c.CarID), bytes)
+}
+
+// confirmedBy reports whether a PSU_OUT reading shows the command took effect. Being within the setpoint is
+// not enough, an output that is still off is within any setpoint.
+func (c Command) confirmedBy(s PSUState) bool {
+	switch {
+	case s.State != PSUApplied:
+		return false
+	case c.Type == CommandDisablePSU:
+		return s.measuredOff()
+	case c.Type == CommandEnablePSU:
+		return s.OutputOn && s.measuredOn()
+	case s.OutputOn: // set_limits
+		return s.measuredOn()
+	default:
+		return s.measuredOff()
+	}
+}
+
+// ackPSUCommands acknowledges the car's sent PSU commands once a PSU_OUT reading taken after them confirms them
+func (srv *Service) ackPSUCommands(carID string, s PSUState) {
+	var finished []Command
+	srv.commands.mutex.Lock()
+	for _, c := range srv.commands.history[carID] {
+		if c.psu() && c.Status == CommandSent && s.MeasuredAt.After(c.SentAt) && c.confirmedBy(s) {
+			c.Status, c.DoneAt = CommandAcked, s.MeasuredAt
+			finished = append(finished, *c)
+		}
+	}
+	srv.commands.mutex.Unlock()
+	for _, c := range finished {
+		srv.commandDone(c)
+	}
+}
+
+func (srv *Service) mqttReceiveCommandAck(ctx context.Context, client mqtt.Client, msg mqtt.Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.WithError(errors.New(fmt.Sprintf("%v", r))).Error("Panic")
+		}
+	}()
+
+	logrus.Debugf("New command acknowledgement %s", msg.Topic())
+
+	var ack commandAck
+	err := json.Unmarshal(msg.Payload(), &ack)
+	if err != nil {
+		logrus.WithError(errors.Wrap(err, "CMD")).Error("Error")
+		return
+	}
+
+	var carID string
+	carID, err = extractCarID(msg, err)
+	if err != nil {
+		logrus.WithError(err).Error("Error")
+		return
+	}
+
+	srv.commands.mutex.Lock()
+	var c *Command
+	for _, h := range srv.commands.history[carID] {
+		if h.ID == ack.ID && !h.psu() {
+			c = h
+		}
+	}
+	if c == nil || c.done() {
+		srv.commands.mutex.Unlock()
+		logrus.Warnf("Car %s acknowledged unknown or finished command %d", carID, ack.ID)
+		return
+	}
+	c.Status, c.DoneAt, c.Reply = CommandAcked, time.Now(), ack.Data
+	if !ack.OK {
+		c.Status, c.Error = CommandFailed, ack.Error
+	}
+	done := *c
+	srv.commands.mutex.Unlock()
+	srv.commandDone(done)
+}
+
+// commandDone logs a command that was acknowledged, failed or timed out and sends it to the car topic
+func (srv *Service) commandDone(c Command) {
+	if c.Status == CommandAcked {
+		logrus.Infof("Command %d %s acknowledged by car %s", c.ID, c.Type, c.CarID)
+	} else {
+		logrus.Warnf("Command %d %s for car %s: %s %s", c.ID, c.Type, c.CarID, c.Status, c.Error)
+	}
+
+	Publish("command", c, carTopic(c.CarID))
+}
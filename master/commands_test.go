@@ -0,0 +1,42 @@
+package master
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandConfirmedBy(t *testing.T) {
+	on := PSUState{State: PSUApplied, CommandedU: 24, CommandedI: 5, OutputOn: true}
+	off := PSUState{State: PSUApplied, CommandedU: 24, CommandedI: 5}
+	reading := func(s PSUState, u, i float64) PSUState {
+		s.MeasuredU, s.MeasuredI = u, i
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		cmd     string
+		state   PSUState
+		confirm bool
+	}{
+		{name: "enable, output at the setpoint", cmd: CommandEnablePSU, state: reading(on, 23.9, 0), confirm: true},
+		{name: "enable, sagging under load", cmd: CommandEnablePSU, state: reading(on, 15, 4.8), confirm: true},
+		{name: "enable, output still off", cmd: CommandEnablePSU, state: reading(on, 0, 0)},
+		{name: "enable, but commanded off", cmd: CommandEnablePSU, state: reading(off, 0, 0)},
+		{name: "disable, output off", cmd: CommandDisablePSU, state: reading(off, 0.3, 0), confirm: true},
+		{name: "disable, output still on", cmd: CommandDisablePSU, state: reading(off, 24, 0)},
+		{name: "raised limits, output still off", cmd: CommandSetLimits, state: reading(on, 0, 0)},
+		{name: "limits, output on", cmd: CommandSetLimits, state: reading(on, 24, 1), confirm: true},
+		{name: "limits, output over the setpoint", cmd: CommandSetLimits, state: func() PSUState {
+			s := reading(on, 24, 8)
+			s.State = PSUMismatch
+			return s
+		}()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.confirm, Command{Type: tt.cmd}.confirmedBy(tt.state))
+		})
+	}
+}
@@ -79,6 +79,10 @@ func (srv *Service) mqttReceivePSU(ctx context.Context, client mqtt.Client, msg
 		Wh:   float32(payload.PSU.Wh),
 		Time: time.Now(),
 	}
+	if payload.PSU.Temp != nil {
+		temp := float32(*payload.PSU.Temp) / 100.0
+		data.Temp = &temp
+	}
 
 	logrus.Debugf("Uop: %f, Iop: %f, Pop: %f, Uip: %f, Wh: %f", data.Uop, data.Iop, data.Pop, data.Uip, data.Wh)
 
@@ -89,7 +93,14 @@ func (srv *Service) mqttReceivePSU(ctx context.Context, client mqtt.Client, msg
 		return
 	}
 
+	srv.telemetry.seen(carID, SensorPSU, data.Time)
 	srv.AllData.UpdateLiveDataCarPSU(carID, float64(data.Pop), float64(data.Uop))
+	srv.checkPSUCompliance(carID, data)
+	values := map[string]float64{"Uop": float64(data.Uop), "Iop": float64(data.Iop), "Pop": float64(data.Pop), "Uip": float64(data.Uip)}
+	if data.Temp != nil {
+		values["Temp"] = float64(*data.Temp)
+	}
+	srv.checkAlerts(carID, values, data.Time)
 
 	org := "Kaste"
 	bucket, err := EnsureBucket(srv.Influxdb, org, "AllData/"+srv.AllData.UUID.String())
@@ -116,7 +127,6 @@ func (srv *Service) mqttReceivePSU(ctx context.Context, client mqtt.Client, msg
 	fields["Pop"] = data.Pop
 	fields["Uip"] = data.Uip
 	fields["Wh"] = consumption
-	var car Car
 	if car, registered := srv.AllData.CarMap[carID]; registered {
 		race = car.CurrentRace
 		if race != nil {
@@ -128,13 +138,6 @@ func (srv *Service) mqttReceivePSU(ctx context.Context, client mqtt.Client, msg
 		}
 	}
 
-	payloadO := dataOutPSU{
-		U:      float32(car.Params.SetVoltage),
-		I:      float32(car.Params.MaxCurrent),
-		Status: 1,
-	}
-	srv.sendPSUData(carID, payloadO)
-
 	logrus.Debugf("Tags: %v, Fields: %v", tags, fields)
 
 	point := write.NewPoint("PSU", tags, fields, data.Time)
@@ -173,31 +176,60 @@ func (srv *Service) mqttReceiveGPS(ctx context.Context, client mqtt.Client, msg
 
 	logrus.Debugf("New GPS value %s", msg.Topic())
 
-	var payload payloadAll
-	err := json.Unmarshal(msg.Payload(), &payload)
+	carID, err := extractCarID(msg, nil)
+	if err != nil {
+		logrus.WithError(err).Error("Error")
+		return
+	}
+
+	fix, err := decodeGPS(msg.Payload(), srv.AllData.CarMap[carID].Params.GPSFormat)
 	if err != nil {
-		logrus.WithError(errors.Wrap(err, "GPS")).Error("Error")
+		logrus.WithError(errors.Wrapf(err, "GPS car %s", carID)).Warn("Dropped")
 		return
 	}
 
 	data := dataGPS{
-		Lat:  payload.GPS.Lat,
-		Lon:  payload.GPS.Lon,
-		Spd:  payload.GPS.Spd,
+		Lat:  fix.Lat,
+		Lon:  fix.Lon,
+		Spd:  float32(fix.Spd),
 		Time: time.Now(),
 	}
 
 	logrus.Debugf("Lat: %f, Lon: %f, Spd: %f", data.Lat, data.Lon, data.Spd)
 
-	var carID string
-	carID, err = extractCarID(msg, err)
+	srv.telemetry.seen(carID, SensorGPS, data.Time)
+	srv.AllData.UpdateLiveDataCarGPS(carID, data.Lat, data.Lon, float64(data.Spd))
+	srv.governSpeed(carID, float64(data.Spd), data.Time)
+	srv.checkAlerts(carID, map[string]float64{"Spd": float64(data.Spd)}, data.Time)
+
+	err = srv.AllData.MqttMessageAny(carID)
 	if err != nil {
 		logrus.WithError(err).Error("Error")
 		return
 	}
 
-	srv.AllData.UpdateLiveDataCarGPS(carID, float64(data.Lat), float64(data.Lon), float64(data.Spd))
-	srv.AllData.CheckSpeed(carID, data.Spd, srv)
+	// The race logic runs before the InfluxDB writes, it must not depend on the storage backend
+	var race *Race
+	var registered bool
+	if car, ok := srv.AllData.CarMap[carID]; ok {
+		race = car.CurrentRace // before a finish clears it
+	}
+	pos := GeoPoint{Lat: data.Lat, Lon: data.Lon}
+	srv.AllData.AccumulateDistance(carID, pos, data.Time)
+	if err := srv.AllData.TrackPitLane(carID, pos, data.Time); err != nil {
+		logrus.WithError(err).Error("Error")
+	}
+	finished, err := srv.AllData.TrackGPS(carID, pos, data.Time)
+	if err != nil {
+		logrus.WithError(err).Error("Error")
+	} else if finished {
+		if err := srv.AllData.CarRaceFinish(FinishInstance{CarID: carID}, srv); err != nil {
+			logrus.WithError(err).Error("Error")
+		} else {
+			logrus.Infof("Car %s finished race %s", carID, race.RaceName)
+			srv.AllData.SaveToFile()
+		}
+	}
 
 	org := "Kaste"
 	bucket, err := EnsureBucket(srv.Influxdb, org, "AllData/"+srv.AllData.UUID.String())
@@ -207,23 +239,14 @@ func (srv *Service) mqttReceiveGPS(ctx context.Context, client mqtt.Client, msg
 	}
 	writeAPI := srv.Influxdb.WriteAPIBlocking(org, bucket)
 
-	err = srv.AllData.MqttMessageAny(carID)
-	if err != nil {
-		logrus.WithError(err).Error("Error")
-		return
-	}
-
 	tags := map[string]string{}
 	fields := map[string]interface{}{}
-	var race *Race
-	var registered bool
 
 	tags["CarID"] = carID
 	fields["Lat"] = data.Lat
 	fields["Lon"] = data.Lon
 	fields["Spd"] = data.Spd
-	if car, registered := srv.AllData.CarMap[carID]; registered {
-		race = car.CurrentRace
+	if _, registered := srv.AllData.CarMap[carID]; registered {
 		if race != nil {
 			fields["Race"] = race.RaceName
 			fields["Lap"] = race.Lap
@@ -296,7 +319,9 @@ func (srv *Service) mqttReceiveAccel(ctx context.Context, client mqtt.Client, ms
 
 	accel := math.Sqrt(float64(data.X*data.X + data.Y*data.Y + data.Z*data.Z))
 
+	srv.telemetry.seen(carID, SensorAccel, data.Time)
 	srv.AllData.UpdateLiveDataCarAccel(carID, accel)
+	srv.checkAlerts(carID, map[string]float64{"Accel": accel}, data.Time)
 
 	org := "Kaste"
 	bucket, err := EnsureBucket(srv.Influxdb, org, "AllData/"+srv.AllData.UUID.String())
@@ -390,6 +415,7 @@ func (srv *Service) mqttReceiveSUS(ctx context.Context, client mqtt.Client, msg
 		logrus.WithError(err).Error("Error")
 		return
 	}
+	srv.telemetry.seen(carID, SensorSUS, time.Now())
 
 	org := "Kaste"
 	bucket, err := EnsureBucket(srv.Influxdb, org, "AllData/"+srv.AllData.UUID.String())
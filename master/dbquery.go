@@ -116,11 +116,11 @@ from(bucket: "CarData")
 		switch raw.Field() {
 		case "Lat":
 			if f, ok := raw.Value().(float64); ok {
-				gps[t].Lat = float32(f)
+				gps[t].Lat = f
 			}
 		case "Lon":
 			if f, ok := raw.Value().(float64); ok {
-				gps[t].Lon = float32(f)
+				gps[t].Lon = f
 			}
 		case "Spd":
 			if f, ok := raw.Value().(float64); ok {
@@ -0,0 +1,90 @@
+package master
+
+import (
+	"fmt"
+	"time"
+)
+
+// Distance sources a race can compute efficiency and average speed from
+const (
+	DistanceNominal  = "nominal"  // the race length
+	DistanceMeasured = "measured" // the distance each car travelled according to its GPS
+)
+
+const (
+	minGPSStep        = 3  // m, shorter steps are receiver jitter
+	maxGPSSpeed       = 40 // m/s, faster steps are outliers
+	maxGPSOutliers    = 3  // consecutive outliers after which the car is taken to be where the GPS says
+	outlierMinElapsed = time.Second
+)
+
+// odometer accumulates the distance between accepted GPS fixes
+type odometer struct {
+	anchor     GeoPoint // last accepted fix
+	anchorTime time.Time
+	hasAnchor  bool
+	outliers   int
+}
+
+func validDistanceSource(source string) error {
+	switch source {
+	case "", DistanceNominal, DistanceMeasured:
+		return nil
+	}
+	return fmt.Errorf("unknown distance source '%s'", source)
+}
+
+// ScoringDistance is the distance the car's efficiency and average speed are computed from.
+// Without a measured distance the nominal length is used.
+func (race Race) ScoringDistance(data RaceData) float64 {
+	if race.DistanceSource == DistanceMeasured && data.Distance > 0 {
+		return data.Distance
+	}
+	return race.Length
+}
+
+// step returns the distance to add for a new fix. Fixes closer than minGPSStep to the last accepted one are
+// skipped without moving the anchor, so slow driving still adds up once it leaves the jitter radius. Fixes
+// implying an impossible speed are dropped, unless several come in a row, which means the GPS lost its fix
+// for a while and the car really is elsewhere. That gap is not counted.
+func (o *odometer) step(fix GeoPoint, at time.Time) float64 {
+	if fix.Lat == 0 && fix.Lon == 0 {
+		return 0 // no fix
+	}
+	if !o.hasAnchor {
+		o.anchor, o.anchorTime, o.hasAnchor = fix, at, true
+		return 0
+	}
+
+	d := haversine(o.anchor, fix)
+	if d < minGPSStep {
+		return 0
+	}
+	elapsed := at.Sub(o.anchorTime)
+	if elapsed < outlierMinElapsed {
+		elapsed = outlierMinElapsed
+	}
+	if d/elapsed.Seconds() > maxGPSSpeed {
+		o.outliers++
+		if o.outliers >= maxGPSOutliers {
+			o.anchor, o.anchorTime, o.outliers = fix, at, 0
+		}
+		return 0
+	}
+	o.anchor, o.anchorTime, o.outliers = fix, at, 0
+	return d
+}
+
+// AccumulateDistance adds the GPS fix to the distance travelled by the car in its current race
+func (a *AllData) AccumulateDistance(carID string, fix GeoPoint, at time.Time) {
+	car, ok := a.CarMap[carID]
+	if !ok || car.CurrentRace == nil {
+		return
+	}
+	data, ok := car.CurrentRace.RaceData[carID]
+	if !ok || !data.RaceMode {
+		return
+	}
+	data.Distance += data.odometer.step(fix, at)
+	car.CurrentRace.RaceData[carID] = data
+}
@@ -0,0 +1,54 @@
+package master
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOdometerStep(t *testing.T) {
+	// north is a point the given number of meters north of a start in Riga
+	north := func(m float64) GeoPoint { return GeoPoint{Lat: 56.9 + m*180/(earthRadius*math.Pi), Lon: 24.1} }
+	type fix struct {
+		pos GeoPoint
+		s   float64 // seconds from the start
+	}
+
+	tests := []struct {
+		name     string
+		fixes    []fix
+		distance float64
+	}{
+		{name: "first fix only sets the anchor", fixes: []fix{{north(0), 0}}},
+		{name: "fixes without a position are ignored", fixes: []fix{{GeoPoint{}, 0}, {north(0), 1}, {GeoPoint{}, 2}, {north(10), 3}}, distance: 10},
+		{name: "steps add up", fixes: []fix{{north(0), 0}, {north(10), 1}, {north(30), 2}}, distance: 30},
+		{name: "jitter is skipped", fixes: []fix{{north(0), 0}, {north(1), 1}, {north(-1), 2}, {north(2), 3}}},
+		{name: "slow driving adds up once out of the jitter radius", fixes: []fix{{north(0), 0}, {north(1), 1}, {north(2), 2}, {north(4), 3}}, distance: 4},
+		{name: "single outlier is dropped", fixes: []fix{{north(0), 0}, {north(500), 1}, {north(10), 2}}, distance: 10},
+		{name: "fixes in quick succession get the minimum elapsed time", fixes: []fix{{north(0), 0}, {north(30), 0.1}}, distance: 30},
+		{
+			name:     "consecutive outliers move the anchor without counting the gap",
+			fixes:    []fix{{north(0), 0}, {north(1000), 1}, {north(1010), 2}, {north(1020), 3}, {north(1040), 4}},
+			distance: 20,
+		},
+		{
+			name:     "an accepted fix resets the outlier count",
+			fixes:    []fix{{north(0), 0}, {north(1000), 1}, {north(10), 2}, {north(1000), 3}, {north(20), 4}, {north(1000), 5}, {north(30), 6}},
+			distance: 30,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var o odometer
+			t0 := time.Now()
+			var distance float64
+			for _, f := range tt.fixes {
+				distance += o.step(f.pos, t0.Add(time.Duration(f.s*float64(time.Second))))
+			}
+			assert.InDelta(t, tt.distance, distance, 0.01)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package master
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const estopRefresh = 1 * time.Second // setpoints are re-asserted this often while the emergency stop is in force
+
+// EStop is the emergency stop. While it is active every car gets PSU Status 0, whatever the limits,
+// commands and the speed governor say. It is saved with AllData so a restart does not release it.
+type EStop struct {
+	Active bool      `json:"Active"`
+	By     string    `json:"By,omitempty"`
+	Reason string    `json:"Reason,omitempty"`
+	Since  time.Time `json:"Since"`
+}
+
+func (srv *Service) GetEStop() EStop {
+	return srv.AllData.EStop
+}
+
+// EmergencyStop cuts the PSU output of every registered car right away, bypassing the rate limiter
+func (srv *Service) EmergencyStop(by, reason string) EStop {
+	if !srv.AllData.EStop.Active {
+		srv.AllData.EStop = EStop{Active: true, By: by, Reason: reason, Since: time.Now()}
+		logrus.Warnf("Emergency stop by %s: %s", by, reason)
+	}
+	if _, err := srv.AbortStart(); err == nil {
+		logrus.Warn("Scheduled start aborted by the emergency stop")
+	}
+	srv.estopChanged("ESTOP")
+	return srv.AllData.EStop
+}
+
+// ReleaseEStop lifts the emergency stop, the cars get their normal setpoints back
+func (srv *Service) ReleaseEStop(by string) (EStop, error) {
+	if !srv.AllData.EStop.Active {
+		return EStop{}, fmt.Errorf("no emergency stop in force")
+	}
+	logrus.Warnf("Emergency stop released by %s after %s", by, time.Since(srv.AllData.EStop.Since))
+	srv.AllData.EStop = EStop{By: by, Since: time.Now()}
+	srv.estopChanged("RELEASE")
+	return srv.AllData.EStop, nil
+}
+
+// estopChanged publishes the setpoints of all cars at once and tells the cars and race control
+func (srv *Service) estopChanged(cmd string) {
+	srv.AllData.UpdateLiveDataEStop(srv.AllData.EStop.Active)
+	for carID := range srv.AllData.CarMap {
+		if err := srv.assertSetpoint(carID); err != nil {
+			logrus.WithError(err).Errorf("PSU setpoint for car %s", carID)
+		}
+	}
+
+	if srv.mqtt != nil {
+		bytes, err := json.Marshal(raceControlMessage{Cmd: cmd, T0: time.Now().UnixMilli()})
+		if err == nil {
+			err = srv.sendAnyTopic(raceControlTopic, bytes)
+		}
+		if err != nil {
+			logrus.WithError(errors.Wrap(err, "RACE_CONTROL")).Error("Error")
+		}
+	}
+
+	Publish("estop", srv.AllData.EStop, TopicAlerts, TopicLive)
+}
+
+// assertSetpoint sends the car's setpoint now, ignoring the rate limiter. During an emergency stop a car whose
+// limits cannot be computed is still stopped, with its last published setpoint or with zero U and I.
+func (srv *Service) assertSetpoint(carID string) error {
+	var sp dataOutPSU
+	if l, err := srv.AllData.CarLimits(carID); err == nil {
+		sp = srv.setpoint(carID, l)
+	} else if !srv.AllData.EStop.Active {
+		return err
+	} else if published, ok := srv.psuPub.published(carID); ok {
+		sp = published
+		sp.Status = 0
+	}
+	srv.psuPub.mutex.Lock()
+	defer srv.psuPub.mutex.Unlock()
+	if srv.psuPub.cars == nil {
+		srv.psuPub.cars = make(map[string]*publishedSetpoint)
+	}
+	return srv.sendSetpoint(carID, sp)
+}
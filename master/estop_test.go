@@ -0,0 +1,37 @@
+package master
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEStopZeroLimits(t *testing.T) {
+	srv := &Service{AllData: AllData{
+		CarMap:   map[string]Car{"1": {Params: Parameters{CarID: "1", SetVoltage: 24}}, "2": {}},
+		LiveData: map[string]LiveDataInstance{},
+	}}
+	srv.AllData.EStop.Active = true
+
+	l, err := srv.AllData.CarLimits("1")
+	assert.NoError(t, err)
+	assert.Zero(t, l.I, "no mass and no race coefficient")
+	sp := srv.setpoint("1", l)
+	assert.Equal(t, 0, sp.Status)
+	payload, err := psuPayload(sp)
+	assert.NoError(t, err, "a stop is sent whatever the limits")
+	assert.Equal(t, 0, payload.PSU.St)
+
+	_, err = srv.AllData.CarLimits("2")
+	assert.Error(t, err, "no set voltage")
+	assert.Error(t, srv.assertSetpoint("2"), "MQTT not connected")
+	pending := srv.psuPub.cars["2"].pending
+	if assert.NotNil(t, pending, "the stop is held for the next refresh") {
+		_, err = psuPayload(*pending)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, pending.Status)
+	}
+
+	_, err = psuPayload(dataOutPSU{U: 24, Status: 1})
+	assert.Error(t, err, "an enabled output needs limits")
+}
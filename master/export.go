@@ -0,0 +1,326 @@
+package master
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatXLSX = "xlsx"
+
+	mimeCSV  = "text/csv"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// Column headers for the spreadsheet exports, per language.
+// Latvian is the default as the organizers use it for prize-giving.
+var exportHeaders = map[string]map[string]string{
+	"lv": {
+		"RaceName":       "Brauciens",
+		"Lap":            "Aplis",
+		"ID":             "ID",
+		"Username":       "Lietotājs",
+		"Used energy":    "Patērētā enerģija (Wh)",
+		"Efficiency":     "Efektivitāte (Wh/km/kg)",
+		"Shelleficiency": "Shell efektivitāte (km/kWh)",
+		"Average power":  "Vidējā jauda (W)",
+		"Average speed":  "Vidējais ātrums (km/h)",
+		"Elapsed time":   "Brauciena laiks (s)",
+		"Distance":       "Distance (m)",
+		"Measured":       "Nobrauktā distance (m)",
+		"Points":         "Punkti",
+		"Position":       "Vieta",
+		"Status":         "Statuss",
+		"Total":          "Kopā (punkti)",
+		"Counted":        "Ieskaitītie punkti",
+		"RelPos":         "Izmaiņa",
+	},
+	"en": {
+		"RaceName":       "Race",
+		"Lap":            "Lap",
+		"ID":             "ID",
+		"Username":       "Username",
+		"Used energy":    "Used energy (Wh)",
+		"Efficiency":     "Efficiency (Wh/km/kg)",
+		"Shelleficiency": "Shelleficiency (km/kWh)",
+		"Average power":  "Average power (W)",
+		"Average speed":  "Average speed (km/h)",
+		"Elapsed time":   "Elapsed time (s)",
+		"Distance":       "Distance (m)",
+		"Measured":       "Measured distance (m)",
+		"Points":         "Points",
+		"Position":       "Position",
+		"Status":         "Status",
+		"Total":          "Total (points)",
+		"Counted":        "Counted total (points)",
+		"RelPos":         "Relative position",
+	},
+}
+
+type exportTable struct {
+	Header []string
+	Rows   [][]interface{} // cells are string, int or float64
+}
+
+// exportFormat picks the response format from the ?format= query, a .csv/.xlsx
+// suffix on the path parameter or the Accept header. The returned name has the suffix removed.
+func exportFormat(r *http.Request, name string) (string, string) {
+	for _, f := range []string{formatCSV, formatXLSX} {
+		if strings.HasSuffix(name, "."+f) {
+			return f, strings.TrimSuffix(name, "."+f)
+		}
+	}
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case formatCSV:
+		return formatCSV, name
+	case formatXLSX:
+		return formatXLSX, name
+	}
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, mimeCSV) {
+		return formatCSV, name
+	}
+	if strings.Contains(accept, mimeXLSX) {
+		return formatXLSX, name
+	}
+	return formatJSON, name
+}
+
+// exportLanguage picks the header language from ?lang= or Accept-Language, defaulting to Latvian
+func exportLanguage(r *http.Request) string {
+	lang := strings.ToLower(r.URL.Query().Get("lang"))
+	if lang == "" {
+		lang = strings.ToLower(r.Header.Get("Accept-Language"))
+		if len(lang) > 2 {
+			lang = lang[:2]
+		}
+	}
+	if _, ok := exportHeaders[lang]; ok {
+		return lang
+	}
+	return "lv"
+}
+
+func exportHeader(lang, key string) string {
+	if h, ok := exportHeaders[lang][key]; ok {
+		return h
+	}
+	return key
+}
+
+func resultsTable(results []Result, lang string) exportTable {
+	keys := []string{"RaceName", "Lap", "Position", "Status", "ID", "Username", "Used energy", "Efficiency", "Shelleficiency", "Average power", "Average speed", "Elapsed time", "Distance", "Measured", "Points"}
+	table := exportTable{}
+	for _, k := range keys {
+		table.Header = append(table.Header, exportHeader(lang, k))
+	}
+	for _, res := range results {
+		table.Rows = append(table.Rows, []interface{}{
+			res.RaceName,
+			res.Lap,
+			res.Position,
+			res.Status,
+			res.CarID,
+			res.Username,
+			res.UsedEnergy,
+			res.Efficiency,
+			res.ShellEff,
+			res.AvgPower,
+			res.AvgSpeed,
+			res.ElapsedTime.Seconds(),
+			res.Distance,
+			res.Measured,
+			res.Points,
+		})
+	}
+	return table
+}
+
+func leaderboardTable(entries []LeaderboardEntry, lang string) exportTable {
+	// Categories differ between entries, so collect all of them in order of appearance
+	var categories []string
+	seen := map[string]bool{}
+	for _, e := range entries {
+		for _, c := range e.Categories {
+			if !seen[c] {
+				seen[c] = true
+				categories = append(categories, c)
+			}
+		}
+	}
+
+	table := exportTable{}
+	table.Header = append(table.Header, exportHeader(lang, "Position"), exportHeader(lang, "ID"), exportHeader(lang, "Username"))
+	table.Header = append(table.Header, categories...)
+	table.Header = append(table.Header, exportHeader(lang, "Total"), exportHeader(lang, "Counted"), exportHeader(lang, "RelPos"))
+
+	for _, e := range entries {
+		points := map[string]int{}
+		for i, c := range e.Categories {
+			if i < len(e.Points) {
+				points[c] = e.Points[i]
+			}
+		}
+		row := []interface{}{e.Position, e.CarID, e.Username}
+		for _, c := range categories {
+			row = append(row, points[c])
+		}
+		row = append(row, e.Total, e.Counted, e.RelPos)
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+func exportCell(v interface{}) string {
+	switch c := v.(type) {
+	case string:
+		return c
+	case int:
+		return strconv.Itoa(c)
+	case float64:
+		if math.IsNaN(c) || math.IsInf(c, 0) {
+			return ""
+		}
+		return strconv.FormatFloat(c, 'f', -1, 64)
+	default:
+		return fmt.Sprintf("%v", c)
+	}
+}
+
+func writeCSV(w io.Writer, table exportTable) error {
+	// UTF-8 BOM so that spreadsheet programs pick up the Latvian characters
+	if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(table.Header); err != nil {
+		return err
+	}
+	for _, row := range table.Rows {
+		record := make([]string, len(row))
+		for i, v := range row {
+			record[i] = exportCell(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeXLSX writes a minimal single-sheet Office Open XML workbook
+func writeXLSX(w io.Writer, sheetName string, table exportTable) error {
+	files := []struct {
+		Name string
+		Body string
+	}{
+		{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+		{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="` + xmlEscape(xlsxSheetName(sheetName)) + `" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+		{"xl/worksheets/sheet1.xml", xlsxSheet(table)},
+	}
+
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(fw, f.Body); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func xlsxSheet(table exportTable) string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	header := make([]interface{}, len(table.Header))
+	for i, h := range table.Header {
+		header[i] = h
+	}
+	rows := append([][]interface{}{header}, table.Rows...)
+	for r, row := range rows {
+		fmt.Fprintf(&sb, `<row r="%d">`, r+1)
+		for c, v := range row {
+			ref := xlsxColumn(c) + strconv.Itoa(r+1)
+			if s, ok := v.(string); ok {
+				fmt.Fprintf(&sb, `<c r="%s" t="inlineStr"><is><t>%s</t></is></c>`, ref, xmlEscape(s))
+			} else if cell := exportCell(v); cell == "" {
+				fmt.Fprintf(&sb, `<c r="%s"/>`, ref)
+			} else {
+				fmt.Fprintf(&sb, `<c r="%s"><v>%s</v></c>`, ref, cell)
+			}
+		}
+		sb.WriteString(`</row>`)
+	}
+	sb.WriteString(`</sheetData></worksheet>`)
+	return sb.String()
+}
+
+// xlsxColumn converts a zero based column index to a spreadsheet column name (0 -> A, 26 -> AA)
+func xlsxColumn(i int) string {
+	name := ""
+	for i >= 0 {
+		name = string(rune('A'+i%26)) + name
+		i = i/26 - 1
+	}
+	return name
+}
+
+// xlsxSheetName strips characters that are not allowed in sheet names and limits the length to 31
+func xlsxSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		name = "Sheet1"
+	}
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	return name
+}
+
+func xmlEscape(s string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(s))
+	return sb.String()
+}
+
+// writeExport writes the table in the requested spreadsheet format as a downloadable file
+func writeExport(w http.ResponseWriter, format, filename string, table exportTable) error {
+	switch format {
+	case formatCSV:
+		w.Header().Set("Content-Type", mimeCSV+"; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+		w.WriteHeader(http.StatusOK)
+		return writeCSV(w, table)
+	case formatXLSX:
+		w.Header().Set("Content-Type", mimeXLSX)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+		w.WriteHeader(http.StatusOK)
+		return writeXLSX(w, filename, table)
+	}
+	return fmt.Errorf("unsupported export format '%s'", format)
+}
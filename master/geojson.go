@@ -0,0 +1,73 @@
+package master
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+// GeoJSON positions are [longitude, latitude]
+func geoJSONPositions(points []GeoPoint) [][]float64 {
+	positions := make([][]float64, 0, len(points))
+	for _, p := range points {
+		positions = append(positions, []float64{p.Lon, p.Lat})
+	}
+	return positions
+}
+
+func geoJSONLine(points []GeoPoint, props map[string]interface{}) geoJSONFeature {
+	return geoJSONFeature{
+		Type:       "Feature",
+		Geometry:   geoJSONGeometry{Type: "LineString", Coordinates: geoJSONPositions(points)},
+		Properties: props,
+	}
+}
+
+// geoJSONPolygon closes the ring as GeoJSON requires the first and last positions to be equal
+func geoJSONPolygon(points []GeoPoint, props map[string]interface{}) geoJSONFeature {
+	ring := geoJSONPositions(points)
+	if len(points) > 0 && points[0] != points[len(points)-1] {
+		ring = append(ring, []float64{points[0].Lon, points[0].Lat})
+	}
+	return geoJSONFeature{
+		Type:       "Feature",
+		Geometry:   geoJSONGeometry{Type: "Polygon", Coordinates: [][][]float64{ring}},
+		Properties: props,
+	}
+}
+
+// GeoJSON returns the track as a feature collection. Every feature carries the track ID and a role:
+// centerline, startFinish, sector or pitLane.
+func (t Track) GeoJSON() GeoJSONFeatureCollection {
+	props := func(role string) map[string]interface{} {
+		return map[string]interface{}{"trackId": t.ID, "role": role}
+	}
+
+	fc := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+	if len(t.Centerline) > 1 {
+		p := props("centerline")
+		p["name"] = t.Name
+		p["length"] = t.LapLength()
+		fc.Features = append(fc.Features, geoJSONLine(t.Centerline, p))
+	}
+	fc.Features = append(fc.Features, geoJSONLine([]GeoPoint{t.StartFinish.A, t.StartFinish.B}, props("startFinish")))
+	for i, s := range t.Sectors {
+		p := props("sector")
+		p["sector"] = i + 1
+		fc.Features = append(fc.Features, geoJSONLine([]GeoPoint{s.A, s.B}, p))
+	}
+	if len(t.PitLane) > 0 {
+		fc.Features = append(fc.Features, geoJSONPolygon(t.PitLane, props("pitLane")))
+	}
+	return fc
+}
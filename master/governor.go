@@ -0,0 +1,285 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Governor actions, taken once a car has been over the speed limit for the trigger time
+const (
+	GovernorWarn   = "warn"   // alert race control only
+	GovernorDerate = "derate" // lower the current limit
+	GovernorCut    = "cut"    // switch the PSU output off, the default
+)
+
+// Governor states
+const (
+	GovernorNormal    = "normal"
+	GovernorOver      = "over"      // above the limit, waiting for the trigger time
+	GovernorActive    = "active"    // intervening
+	GovernorReleasing = "releasing" // below the release speed, waiting for the release time
+)
+
+// Governor event kinds
+const (
+	GovernorIntervene = "intervene"
+	GovernorRelease   = "release"
+)
+
+const (
+	defaultGovernorTrigger    = 1.0 // s
+	defaultGovernorRelease    = 2.0 // s
+	defaultGovernorHysteresis = 2.0 // speed units below MaxSpd
+	defaultGovernorDerate     = 0.5
+	governorMaxEvents         = 1000
+)
+
+// GovernorRules configure the speed governor. The limit itself is the settings' MaxSpd, 0 turns the governor off.
+type GovernorRules struct {
+	Action      string  `json:"Action"`      // warn, derate or cut (default)
+	TriggerTime float64 `json:"TriggerTime"` // s over the limit before intervening, 0 for the default
+	ReleaseTime float64 `json:"ReleaseTime"` // s below the release speed before the intervention is lifted, 0 for the default
+	Hysteresis  float64 `json:"Hysteresis"`  // the release speed is this far below MaxSpd, 0 for the default
+	Derate      float64 `json:"Derate"`      // fraction of the current limit kept when derating, 0 for the default
+}
+
+// GovernorState is where a car is in the governor's state machine
+type GovernorState struct {
+	CarID  string    `json:"ID"`
+	State  string    `json:"State"`
+	Action string    `json:"Action,omitempty"` // the intervention in force
+	Speed  float64   `json:"Speed"`            // last sample
+	Since  time.Time `json:"Since"`            // when the state last changed
+	Active time.Time `json:"Active"`           // when the intervention started
+}
+
+// GovernorEvent records an intervention starting or being lifted
+type GovernorEvent struct {
+	Seq      int           `json:"Seq"`
+	CarID    string        `json:"ID"`
+	Kind     string        `json:"Kind"` // intervene or release
+	Action   string        `json:"Action"`
+	Speed    float64       `json:"Speed"`
+	Limit    float64       `json:"Limit"`
+	Time     time.Time     `json:"Time"`
+	Duration time.Duration `json:"Duration,omitempty"` // how long the intervention lasted, on release
+}
+
+type speedGovernor struct {
+	mutex  sync.Mutex
+	states map[string]GovernorState // map of [carID]
+	events []GovernorEvent
+	seq    int
+}
+
+// Validate checks the rules against the speed limit they are used with
+func (r GovernorRules) Validate(limit float64) error {
+	switch r.Action {
+	case "", GovernorWarn, GovernorDerate, GovernorCut:
+	default:
+		return fmt.Errorf("unknown governor action '%s'", r.Action)
+	}
+	if r.TriggerTime < 0 || r.ReleaseTime < 0 || r.Hysteresis < 0 {
+		return fmt.Errorf("governor times and hysteresis must not be negative")
+	}
+	if r.Derate < 0 || r.Derate > 1 {
+		return fmt.Errorf("governor derate must be a fraction between 0 and 1")
+	}
+	if limit > 0 && r.hysteresis() >= limit {
+		return fmt.Errorf("governor hysteresis %.1f must be below the speed limit %.1f, or the car is never released", r.hysteresis(), limit)
+	}
+	return nil
+}
+
+func (r GovernorRules) action() string {
+	if r.Action == "" {
+		return GovernorCut
+	}
+	return r.Action
+}
+
+func (r GovernorRules) trigger() time.Duration {
+	if r.TriggerTime > 0 {
+		return time.Duration(r.TriggerTime * float64(time.Second))
+	}
+	return time.Duration(defaultGovernorTrigger * float64(time.Second))
+}
+
+func (r GovernorRules) release() time.Duration {
+	if r.ReleaseTime > 0 {
+		return time.Duration(r.ReleaseTime * float64(time.Second))
+	}
+	return time.Duration(defaultGovernorRelease * float64(time.Second))
+}
+
+func (r GovernorRules) hysteresis() float64 {
+	if r.Hysteresis > 0 {
+		return r.Hysteresis
+	}
+	return defaultGovernorHysteresis
+}
+
+// releaseSpeed is the speed the car must stay below to be released. It is kept above 0 for hysteresis
+// saved before it was checked against the limit, a car that has stopped is always released.
+func (r GovernorRules) releaseSpeed(limit float64) float64 {
+	if release := limit - r.hysteresis(); release > 0 {
+		return release
+	}
+	return limit / 2
+}
+
+func (r GovernorRules) derate() float64 {
+	if r.Derate > 0 {
+		return r.Derate
+	}
+	return defaultGovernorDerate
+}
+
+// sample feeds a speed sample to the car's state machine. It returns the event when an intervention starts or is lifted.
+func (g *speedGovernor) sample(carID string, speed, limit float64, rules GovernorRules, at time.Time) (GovernorEvent, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.states == nil {
+		g.states = make(map[string]GovernorState)
+	}
+	s, ok := g.states[carID]
+	if !ok {
+		s = GovernorState{CarID: carID, State: GovernorNormal, Since: at}
+	}
+	s.Speed = speed
+
+	var kind string
+	set := func(state string) {
+		s.State, s.Since = state, at
+	}
+	switch {
+	case limit <= 0:
+		if s.Action != "" {
+			kind = GovernorRelease
+		}
+		if s.State != GovernorNormal {
+			set(GovernorNormal)
+		}
+	case s.State == GovernorNormal:
+		if speed > limit {
+			set(GovernorOver)
+		}
+	case s.State == GovernorOver:
+		if speed <= limit {
+			set(GovernorNormal)
+		} else if at.Sub(s.Since) >= rules.trigger() {
+			set(GovernorActive)
+			s.Action, s.Active = rules.action(), at
+			kind = GovernorIntervene
+		}
+	case s.State == GovernorActive:
+		if speed < rules.releaseSpeed(limit) {
+			set(GovernorReleasing)
+		}
+	case s.State == GovernorReleasing:
+		if speed >= rules.releaseSpeed(limit) {
+			set(GovernorActive)
+		} else if at.Sub(s.Since) >= rules.release() {
+			set(GovernorNormal)
+			kind = GovernorRelease
+		}
+	}
+
+	var e GovernorEvent
+	if kind != "" {
+		g.seq++
+		e = GovernorEvent{Seq: g.seq, CarID: carID, Kind: kind, Action: s.Action, Speed: speed, Limit: limit, Time: at}
+		if kind == GovernorRelease {
+			e.Duration = at.Sub(s.Active)
+			s.Action, s.Active = "", time.Time{}
+		}
+		g.events = append(g.events, e)
+		if len(g.events) > governorMaxEvents {
+			g.events = g.events[len(g.events)-governorMaxEvents:]
+		}
+	}
+	g.states[carID] = s
+	return e, kind != ""
+}
+
+// action is the intervention in force for the car, empty when there is none
+func (g *speedGovernor) action(carID string) string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.states[carID].Action
+}
+
+func (g *speedGovernor) all() []GovernorState {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	states := make([]GovernorState, 0, len(g.states))
+	for _, s := range g.states {
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].CarID < states[j].CarID })
+	return states
+}
+
+// eventsOf returns the logged events, of one car when carID is not empty, oldest first
+func (g *speedGovernor) eventsOf(carID string) []GovernorEvent {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	events := []GovernorEvent{}
+	for _, e := range g.events {
+		if carID == "" || e.CarID == carID {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (g *speedGovernor) remove(carID string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.states, carID)
+}
+
+func (srv *Service) GetGovernorStates() []GovernorState {
+	return srv.governor.all()
+}
+
+func (srv *Service) GetGovernorEvents(carID string) []GovernorEvent {
+	return srv.governor.eventsOf(carID)
+}
+
+// governSpeed runs a GPS speed sample through the governor and applies, logs and broadcasts any intervention
+func (srv *Service) governSpeed(carID string, speed float64, at time.Time) {
+	rules := srv.AllData.Settings.Governor
+	e, ok := srv.governor.sample(carID, speed, srv.AllData.Settings.MaxSpeed, rules, at)
+	if !ok {
+		return
+	}
+	srv.AllData.UpdateLiveDataCarGovernor(carID, srv.governor.action(carID))
+	if e.Kind == GovernorIntervene {
+		logrus.Warnf("Car %s over the speed limit (%.1f > %.1f), governor action: %s", carID, e.Speed, e.Limit, e.Action)
+	} else {
+		logrus.Infof("Car %s governor %s lifted after %s", carID, e.Action, e.Duration)
+	}
+	if e.Action != GovernorWarn {
+		if err := srv.publishCarSetpoint(carID); err != nil {
+			logrus.WithError(err).Errorf("PSU setpoint for car %s", carID)
+		}
+	}
+
+	Publish("governor", e, TopicAlerts, carTopic(carID))
+}
+
+// governed applies the governor's intervention for the car to a setpoint
+func (srv *Service) governed(carID string, sp dataOutPSU) dataOutPSU {
+	switch srv.governor.action(carID) {
+	case GovernorDerate:
+		sp.I *= float32(srv.AllData.Settings.Governor.derate())
+	case GovernorCut:
+		sp.Status = 0
+	}
+	return sp
+}
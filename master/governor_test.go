@@ -0,0 +1,86 @@
+package master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGovernorSample(t *testing.T) {
+	t0 := time.Now()
+	at := func(s float64) time.Time { return t0.Add(time.Duration(s * float64(time.Second))) }
+	rules := GovernorRules{Action: GovernorDerate, TriggerTime: 1, ReleaseTime: 2, Hysteresis: 3}
+
+	type sample struct {
+		t     float64
+		speed float64
+		state string
+		event string
+	}
+	tests := []struct {
+		name    string
+		limit   float64
+		invalid bool
+		samples []sample
+	}{
+		{
+			name:  "short spike is ignored",
+			limit: 30,
+			samples: []sample{
+				{0, 31, GovernorOver, ""},
+				{0.5, 32, GovernorOver, ""},
+				{0.8, 29, GovernorNormal, ""},
+				{1.5, 29, GovernorNormal, ""},
+			},
+		},
+		{
+			name:  "intervene and release with hysteresis",
+			limit: 30,
+			samples: []sample{
+				{0, 31, GovernorOver, ""},
+				{1, 31, GovernorActive, GovernorIntervene},
+				{2, 29, GovernorActive, ""}, // not below the release speed of 27
+				{3, 26, GovernorReleasing, ""},
+				{4, 28, GovernorActive, ""},
+				{5, 26, GovernorReleasing, ""},
+				{7, 25, GovernorNormal, GovernorRelease},
+			},
+		},
+		{
+			name:    "hysteresis above the limit still releases",
+			limit:   2,
+			invalid: true,
+			samples: []sample{
+				{0, 3, GovernorOver, ""},
+				{1, 3, GovernorActive, GovernorIntervene},
+				{2, 1.5, GovernorActive, ""}, // not below the release speed of 1
+				{3, 0, GovernorReleasing, ""},
+				{5, 0, GovernorNormal, GovernorRelease},
+			},
+		},
+		{
+			name:  "turned off",
+			limit: 0,
+			samples: []sample{
+				{0, 100, GovernorNormal, ""},
+				{5, 100, GovernorNormal, ""},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.invalid, rules.Validate(tt.limit) != nil, "rejected when the settings are saved")
+			var g speedGovernor
+			for _, s := range tt.samples {
+				e, ok := g.sample("1", s.speed, tt.limit, rules, at(s.t))
+				assert.Equal(t, s.state, g.states["1"].State, "t=%v", s.t)
+				assert.Equal(t, s.event != "", ok, "t=%v", s.t)
+				if ok {
+					assert.Equal(t, s.event, e.Kind)
+					assert.Equal(t, GovernorDerate, e.Action)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package master
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GPS coordinate formats a car can send on GPS_OUT, set per car in Parameters.GPSFormat
+const (
+	GPSFormatDecimal  = "decimal"  // decimal degrees, the default
+	GPSFormatNMEA     = "nmea"     // NMEA ddmm.mmmm as a number
+	GPSFormatNMEA100  = "nmea100"  // NMEA ddmm.mmmm multiplied by 100, e.g. 565656.20
+	GPSFormatSentence = "sentence" // raw $GPRMC or $GPGGA sentences
+)
+
+const (
+	knotsToKmh = 1.852
+	maxHDOP    = 10 // fixes less precise than this are dropped
+	minSats    = 4
+)
+
+type gpsFix struct {
+	Lat float64
+	Lon float64
+	Spd float64 // km/h
+}
+
+func validGPSFormat(format string) error {
+	switch format {
+	case "", GPSFormatDecimal, GPSFormatNMEA, GPSFormatNMEA100, GPSFormatSentence:
+		return nil
+	}
+	return fmt.Errorf("unknown GPS format '%s'", format)
+}
+
+// nmeaToDegrees converts a ddmm.mmmm (or dddmm.mmmm) value to decimal degrees. Minutes of 60 or more mean
+// the value is not in that format, e.g. decimal degrees sent to a car set up for NMEA.
+func nmeaToDegrees(v float64) (float64, error) {
+	sign := 1.0
+	if v < 0 {
+		sign, v = -1, -v
+	}
+	deg := math.Floor(v / 100)
+	minutes := v - deg*100
+	if minutes >= 60 {
+		return 0, fmt.Errorf("%v is not ddmm.mmmm, minutes %.4f are not below 60", sign*v, minutes)
+	}
+	return sign * (deg + minutes/60), nil
+}
+
+// nmeaLatLon converts an NMEA latitude and longitude to decimal degrees
+func nmeaLatLon(lat, lon float64) (float64, float64, error) {
+	lat, err := nmeaToDegrees(lat)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "latitude")
+	}
+	lon, err = nmeaToDegrees(lon)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "longitude")
+	}
+	return lat, lon, nil
+}
+
+// decodeGPS turns a GPS_OUT payload in the car's format into decimal degrees. Payloads without a usable fix
+// are rejected. Sentences may come as the whole payload or in the NMEA field of the JSON payload.
+func decodeGPS(raw []byte, format string) (gpsFix, error) {
+	var fix gpsFix
+	text := strings.TrimSpace(string(raw))
+	if strings.HasPrefix(text, "$") {
+		return decodeNMEASentences(text)
+	}
+
+	var payload payloadAll
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return fix, errors.Wrap(err, "Unmarshal")
+	}
+	gps := payload.GPS
+	if format == GPSFormatSentence || gps.NMEA != "" {
+		return decodeNMEASentences(gps.NMEA)
+	}
+
+	var err error
+	switch format {
+	case "", GPSFormatDecimal:
+		fix.Lat, fix.Lon = gps.Lat, gps.Lon
+	case GPSFormatNMEA:
+		fix.Lat, fix.Lon, err = nmeaLatLon(gps.Lat, gps.Lon)
+	case GPSFormatNMEA100:
+		fix.Lat, fix.Lon, err = nmeaLatLon(gps.Lat/100, gps.Lon/100)
+	default:
+		return fix, validGPSFormat(format)
+	}
+	if err != nil {
+		return fix, err
+	}
+	fix.Spd = gps.Spd
+
+	// Satellites and HDOP are optional in the JSON payload, zero means the car does not send them
+	if gps.Sats > 0 && gps.Sats < minSats {
+		return fix, fmt.Errorf("only %d satellites in view", gps.Sats)
+	}
+	if gps.HDOP > maxHDOP {
+		return fix, fmt.Errorf("HDOP %.1f is above %d", gps.HDOP, maxHDOP)
+	}
+	return fix, checkFix(fix)
+}
+
+func checkFix(fix gpsFix) error {
+	if fix.Lat == 0 && fix.Lon == 0 {
+		return errors.New("no GPS fix")
+	}
+	return GeoPoint{Lat: fix.Lat, Lon: fix.Lon}.Validate()
+}
+
+// decodeNMEASentences reads the position from RMC and quality from GGA sentences, one per line
+func decodeNMEASentences(text string) (gpsFix, error) {
+	var fix gpsFix
+	var found bool
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		fields, err := nmeaFields(strings.TrimSpace(line))
+		if err != nil {
+			return fix, err
+		}
+		switch {
+		case strings.HasSuffix(fields[0], "RMC") && len(fields) >= 8:
+			if fields[2] != "A" {
+				return fix, fmt.Errorf("%s reports no valid fix", fields[0])
+			}
+			if fix.Lat, fix.Lon, err = nmeaPosition(fields[3:7]); err != nil {
+				return fix, err
+			}
+			if knots, err := strconv.ParseFloat(fields[7], 64); err == nil {
+				fix.Spd = knots * knotsToKmh
+			}
+			found = true
+		case strings.HasSuffix(fields[0], "GGA") && len(fields) >= 9:
+			if fields[6] == "" || fields[6] == "0" {
+				return fix, fmt.Errorf("%s reports no valid fix", fields[0])
+			}
+			if sats, err := strconv.Atoi(fields[7]); err == nil && sats < minSats {
+				return fix, fmt.Errorf("only %d satellites in view", sats)
+			}
+			if hdop, err := strconv.ParseFloat(fields[8], 64); err == nil && hdop > maxHDOP {
+				return fix, fmt.Errorf("HDOP %.1f is above %d", hdop, maxHDOP)
+			}
+			if !found {
+				if fix.Lat, fix.Lon, err = nmeaPosition(fields[2:6]); err != nil {
+					return fix, err
+				}
+				found = true
+			}
+		}
+	}
+	if !found {
+		return fix, errors.New("no RMC or GGA sentence")
+	}
+	return fix, checkFix(fix)
+}
+
+// nmeaFields verifies the checksum of the sentence and splits it into fields without the leading $
+func nmeaFields(sentence string) ([]string, error) {
+	if !strings.HasPrefix(sentence, "$") {
+		return nil, fmt.Errorf("'%s' is not an NMEA sentence", sentence)
+	}
+	body := sentence[1:]
+	if i := strings.IndexByte(body, '*'); i >= 0 {
+		want, err := strconv.ParseUint(body[i+1:], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid checksum in '%s'", sentence)
+		}
+		var sum byte
+		for j := 0; j < i; j++ {
+			sum ^= body[j]
+		}
+		if uint64(sum) != want {
+			return nil, fmt.Errorf("checksum mismatch in '%s'", sentence)
+		}
+		body = body[:i]
+	}
+	return strings.Split(body, ","), nil
+}
+
+// nmeaPosition parses the latitude, N/S, longitude, E/W fields
+func nmeaPosition(f []string) (float64, float64, error) {
+	lat, err := strconv.ParseFloat(f[0], 64)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "latitude")
+	}
+	lon, err := strconv.ParseFloat(f[2], 64)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "longitude")
+	}
+	if lat, lon, err = nmeaLatLon(lat, lon); err != nil {
+		return 0, 0, err
+	}
+	if f[1] == "S" {
+		lat = -lat
+	}
+	if f[3] == "W" {
+		lon = -lon
+	}
+	return lat, lon, nil
+}
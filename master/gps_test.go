@@ -0,0 +1,48 @@
+package master
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeGPS(t *testing.T) {
+	const rmc = "$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,003.1,W*6A"
+
+	tests := []struct {
+		name    string
+		payload string
+		format  string
+		lat     float64
+		lon     float64
+		spd     float64
+		wantErr bool
+	}{
+		{name: "decimal degrees", payload: `{"GPS":{"Lat":56.9427,"Lon":24.2244,"Spd":2}}`, lat: 56.9427, lon: 24.2244, spd: 2},
+		{name: "nmea", payload: `{"GPS":{"Lat":5656.562,"Lon":2413.464,"Spd":2}}`, format: GPSFormatNMEA, lat: 56.9427, lon: 24.2244, spd: 2},
+		{name: "nmea times 100", payload: `{"GPS":{"Lat":565656.2,"Lon":241346.4}}`, format: GPSFormatNMEA100, lat: 56.9427, lon: 24.2244},
+		{name: "raw sentence", payload: rmc, lat: 48.1173, lon: 11.516667, spd: 41.4848},
+		{name: "sentence in json", payload: `{"GPS":{"NMEA":"` + rmc + `"}}`, format: GPSFormatSentence, lat: 48.1173, lon: 11.516667, spd: 41.4848},
+		{name: "no fix", payload: `{"GPS":{"Lat":0,"Lon":0}}`, wantErr: true},
+		{name: "too few satellites", payload: `{"GPS":{"Lat":56.9,"Lon":24.1,"Sats":2}}`, wantErr: true},
+		{name: "minutes over 60", payload: `{"GPS":{"Lat":569427.00,"Lon":242244.53}}`, format: GPSFormatNMEA100, wantErr: true},
+		{name: "sentence minutes over 60", payload: "$GPRMC,123519,A,5694.270,N,02422.445,E,0,0,230394,,*17", wantErr: true},
+		{name: "out of range", payload: `{"GPS":{"Lat":569427.00,"Lon":242244.53}}`, wantErr: true},
+		{name: "bad checksum", payload: rmc[:len(rmc)-1] + "B", wantErr: true},
+		{name: "invalid gga fix", payload: "$GPGGA,123519,4807.038,N,01131.000,E,0,08,0.9,545.4,M,46.9,M,,*46", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fix, err := decodeGPS([]byte(tt.payload), tt.format)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDelta(t, tt.lat, fix.Lat, 1e-6)
+			assert.InDelta(t, tt.lon, fix.Lon, 1e-6)
+			assert.InDelta(t, tt.spd, fix.Spd, 1e-3)
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package master
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type LapTime struct {
+	Lap     int             `json:"Lap"`
+	Time    time.Duration   `json:"Time"`
+	Sectors []time.Duration `json:"Sectors,omitempty"` // only when every sector line was crossed in order
+}
+
+// lapTracker holds the line-crossing state of a car between GPS fixes
+type lapTracker struct {
+	last       GeoPoint
+	lastTime   time.Time
+	hasFix     bool
+	lapStart   time.Time // zero until the car first crosses the start/finish line
+	direction  float64   // side the car crossed the start/finish line from, laps only count in that direction
+	splitStart time.Time
+	sector     int // index of the next sector line to cross
+	splits     []time.Duration
+}
+
+// segmentCrossing tells if the path from p to q crosses the line, where along the path, 0 at p and 1 at q,
+// and from which side, positive or negative. The coordinates are projected onto a local plane, which is
+// accurate enough over the length of a track.
+func segmentCrossing(p, q GeoPoint, l GateLine) (float64, float64, bool) {
+	scale := math.Cos(p.Lat * math.Pi / 180)
+	px, py := p.Lon*scale, p.Lat
+	rx, ry := q.Lon*scale-px, q.Lat-py
+	ax, ay := l.A.Lon*scale, l.A.Lat
+	sx, sy := l.B.Lon*scale-ax, l.B.Lat-ay
+
+	denom := rx*sy - ry*sx
+	if denom == 0 {
+		return 0, 0, false // parallel, a car driving along the line does not cross it
+	}
+	t := ((ax-px)*sy - (ay-py)*sx) / denom
+	u := ((ax-px)*ry - (ay-py)*rx) / denom
+	if t < 0 || t > 1 || u < 0 || u > 1 {
+		return 0, 0, false
+	}
+	return t, math.Copysign(1, denom), true
+}
+
+// TrackGPS feeds a GPS fix of the car into the lap detection of its current race. The first crossing of the
+// start/finish line opens lap 1, every following one closes a lap. It returns true once the car has completed
+// the number of laps the race is set to.
+func (a *AllData) TrackGPS(carID string, fix GeoPoint, at time.Time) (bool, error) {
+	car, ok := a.CarMap[carID]
+	if !ok || car.CurrentRace == nil {
+		return false, nil
+	}
+	race, ok := a.Races[raceKey(*car.CurrentRace)]
+	if !ok {
+		race = *car.CurrentRace
+	}
+	if race.TrackID == "" {
+		return false, nil
+	}
+	track, ok := a.Tracks[race.TrackID]
+	if !ok {
+		return false, fmt.Errorf("track '%s' of race '%s' not found", race.TrackID, race.RaceName)
+	}
+	data, ok := race.RaceData[carID]
+	if !ok || !data.RaceMode {
+		return false, nil
+	}
+
+	tr := data.track
+	if tr.hasFix && at.After(tr.lastTime) {
+		crossedAt := func(t float64) time.Time {
+			return tr.lastTime.Add(time.Duration(t * float64(at.Sub(tr.lastTime))))
+		}
+		minLap := time.Duration(track.MinLapTime * float64(time.Second))
+		if minLap == 0 {
+			minLap = defaultMinLapTime * time.Second
+		}
+
+		if t, side, crossed := segmentCrossing(tr.last, fix, track.StartFinish); crossed {
+			crossing := crossedAt(t)
+			switch {
+			case tr.lapStart.IsZero():
+				tr.direction = side
+				logrus.Infof("Car %s started lap 1 of race %s", carID, race.RaceName)
+			case side != tr.direction || crossing.Sub(tr.lapStart) < minLap:
+				crossing = time.Time{} // jitter around the line or driving back over it
+			default:
+				lap := LapTime{Lap: len(data.Laps) + 1, Time: crossing.Sub(tr.lapStart)}
+				if len(track.Sectors) > 0 && tr.sector == len(track.Sectors) {
+					lap.Sectors = append(tr.splits, crossing.Sub(tr.splitStart))
+				}
+				data.Laps = append(data.Laps, lap)
+				logrus.Infof("Car %s completed lap %d of race %s in %s", carID, lap.Lap, race.RaceName, lap.Time)
+			}
+			if !crossing.IsZero() {
+				tr.lapStart, tr.splitStart = crossing, crossing
+				tr.sector, tr.splits = 0, nil
+			}
+		} else if !tr.lapStart.IsZero() && tr.sector < len(track.Sectors) {
+			if t, _, crossed := segmentCrossing(tr.last, fix, track.Sectors[tr.sector]); crossed {
+				crossing := crossedAt(t)
+				tr.splits = append(tr.splits, crossing.Sub(tr.splitStart))
+				tr.splitStart = crossing
+				tr.sector++
+			}
+		}
+	}
+	tr.last, tr.lastTime, tr.hasFix = fix, at, true
+
+	data.track = tr
+	race.RaceData[carID] = data
+	return race.Laps > 0 && len(data.Laps) >= race.Laps, nil
+}
@@ -0,0 +1,136 @@
+package master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A start/finish line across the equator at 0° E, crossed eastwards at lat 0, and one sector line
+// north of it, crossed northwards at lon 0.0001
+var (
+	testStartFinish = GateLine{A: GeoPoint{Lat: -0.001}, B: GeoPoint{Lat: 0.001}}
+	testSector      = GateLine{A: GeoPoint{Lat: 0.005, Lon: 0.00005}, B: GeoPoint{Lat: 0.005, Lon: 0.001}}
+
+	westOfLine = GeoPoint{Lat: 0, Lon: -0.0001}
+	eastOfLine = GeoPoint{Lat: 0, Lon: 0.0001}
+	northEast  = GeoPoint{Lat: 0.01, Lon: 0.0001}
+	northWest  = GeoPoint{Lat: 0.01, Lon: -0.0001}
+)
+
+func TestSegmentCrossing(t *testing.T) {
+	tests := []struct {
+		name    string
+		p, q    GeoPoint
+		line    GateLine
+		at      float64
+		side    float64
+		crossed bool
+	}{
+		{name: "crossed halfway", p: westOfLine, q: eastOfLine, line: testStartFinish, at: 0.5, side: 1, crossed: true},
+		{name: "crossed the other way", p: eastOfLine, q: westOfLine, line: testStartFinish, at: 0.5, side: -1, crossed: true},
+		{name: "crossed early on the path", p: GeoPoint{Lon: -0.0001}, q: GeoPoint{Lon: 0.0003}, line: testStartFinish, at: 0.25, side: 1, crossed: true},
+		{name: "path ends before the line", p: GeoPoint{Lon: -0.0002}, q: westOfLine, line: testStartFinish},
+		{name: "past the end of the line", p: northWest, q: northEast, line: testStartFinish},
+		{name: "driving along the line", p: GeoPoint{Lat: -0.0005}, q: GeoPoint{Lat: 0.0005}, line: testStartFinish},
+		{name: "sector line", p: eastOfLine, q: northEast, line: testSector, at: 0.5, side: -1, crossed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, side, crossed := segmentCrossing(tt.p, tt.q, tt.line)
+			assert.Equal(t, tt.crossed, crossed)
+			if tt.crossed {
+				assert.InDelta(t, tt.at, at, 1e-9)
+				assert.Equal(t, tt.side, side)
+			}
+		})
+	}
+}
+
+func TestTrackGPS(t *testing.T) {
+	type fix struct {
+		pos GeoPoint
+		s   float64 // seconds from the start
+	}
+	sec := func(s float64) time.Duration { return time.Duration(s * float64(time.Second)) }
+
+	tests := []struct {
+		name       string
+		fixes      []fix
+		minLapTime float64
+		raceLaps   int
+		laps       []LapTime
+		done       bool
+	}{
+		{
+			name:  "first crossing opens the lap",
+			fixes: []fix{{westOfLine, 0}, {eastOfLine, 2}, {northEast, 10}},
+		},
+		{
+			name:     "lap with sector splits",
+			fixes:    []fix{{westOfLine, 0}, {eastOfLine, 2}, {northEast, 10}, {northWest, 20}, {westOfLine, 30}, {eastOfLine, 32}},
+			raceLaps: 1,
+			laps:     []LapTime{{Lap: 1, Time: sec(30), Sectors: []time.Duration{sec(5), sec(25)}}},
+			done:     true,
+		},
+		{
+			name:     "driving back over the line does not close the lap",
+			fixes:    []fix{{westOfLine, 0}, {eastOfLine, 2}, {westOfLine, 20}, {eastOfLine, 40}},
+			raceLaps: 2,
+			laps:     []LapTime{{Lap: 1, Time: sec(29)}},
+		},
+		{
+			name: "crossings sooner than the minimum lap time are jitter",
+			fixes: []fix{{westOfLine, 0}, {eastOfLine, 2}, {northEast, 4}, {northWest, 6}, {westOfLine, 8}, {eastOfLine, 10},
+				{northEast, 12}, {northWest, 14}, {westOfLine, 16}, {eastOfLine, 30}},
+			minLapTime: 20,
+			laps:       []LapTime{{Lap: 1, Time: sec(22), Sectors: []time.Duration{sec(2), sec(20)}}},
+		},
+		{
+			name:  "default minimum lap time",
+			fixes: []fix{{westOfLine, 0}, {eastOfLine, 2}, {northEast, 3}, {northWest, 4}, {westOfLine, 5}, {eastOfLine, 7}},
+		},
+		{
+			name:  "no splits when a sector line was missed",
+			fixes: []fix{{westOfLine, 0}, {eastOfLine, 2}, {northWest, 10}, {westOfLine, 20}, {eastOfLine, 22}},
+			laps:  []LapTime{{Lap: 1, Time: sec(20)}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			race := Race{RaceName: "R", Lap: 1, TrackID: "t", Laps: tt.raceLaps, RaceData: map[string]RaceData{"1": {RaceMode: true}}}
+			a := AllData{
+				CarMap: map[string]Car{"1": {Params: Parameters{CarID: "1"}, CurrentRace: &race}},
+				Races:  map[string]Race{raceKey(race): race},
+				Tracks: map[string]Track{"t": {ID: "t", StartFinish: testStartFinish, Sectors: []GateLine{testSector}, MinLapTime: tt.minLapTime}},
+			}
+
+			t0 := time.Now()
+			var done bool
+			for _, f := range tt.fixes {
+				var err error
+				done, err = a.TrackGPS("1", f.pos, t0.Add(sec(f.s)))
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.done, done)
+
+			laps := a.Races[raceKey(race)].RaceData["1"].Laps
+			assert.Len(t, laps, len(tt.laps))
+			for i := range tt.laps {
+				if i < len(laps) {
+					assert.Equal(t, tt.laps[i].Lap, laps[i].Lap)
+					assert.InDelta(t, tt.laps[i].Time, laps[i].Time, float64(time.Millisecond))
+					assert.Len(t, laps[i].Sectors, len(tt.laps[i].Sectors))
+					for j := range tt.laps[i].Sectors {
+						if j < len(laps[i].Sectors) {
+							assert.InDelta(t, tt.laps[i].Sectors[j], laps[i].Sectors[j], float64(time.Millisecond))
+						}
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package master
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Name of the leaderboard that contains every car regardless of age group
+const overallLeaderboard = "all"
+
+// BuildLeaderboard sums each car's points per category (race name) over the given races and
+// orders the cars by the total counted by the championship rules. Ties are broken by number
+// of wins, then best finishing position and finally car ID, so the order is the same on every call.
+// RelPos is the change in position against the baseline, positive when the car moved up.
+func BuildLeaderboard(cars []Parameters, races []Race, baseline []LeaderboardEntry, rules ChampionshipRules) []LeaderboardEntry {
+	// Process races in a fixed order so that category order does not depend on map iteration
+	sorted := make([]Race, len(races))
+	copy(sorted, races)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].RaceName != sorted[j].RaceName {
+			return sorted[i].RaceName < sorted[j].RaceName
+		}
+		return sorted[i].Lap < sorted[j].Lap
+	})
+
+	// Every category counted for the group, a car that missed one has 0 points in it so that
+	// DropWorst and BestOf drop the missed race rather than one of its results
+	var categories []string
+	index := map[string]int{} // index of the category in categories
+	for _, race := range sorted {
+		if _, ok := index[race.RaceName]; !ok {
+			index[race.RaceName] = len(categories)
+			categories = append(categories, race.RaceName)
+		}
+	}
+
+	previous := make(map[string]int, len(baseline))
+	for _, e := range baseline {
+		previous[e.CarID] = e.Position
+	}
+
+	entries := make([]LeaderboardEntry, 0, len(cars))
+	for _, car := range cars {
+		entry := LeaderboardEntry{
+			CarID:      car.CarID,
+			Username:   car.Username,
+			Avatar:     car.Avatar,
+			Categories: append([]string(nil), categories...),
+			Points:     make([]int, len(categories)),
+		}
+		participated := false
+		for _, race := range sorted {
+			data, ok := race.RaceData[car.CarID]
+			if !ok {
+				continue
+			}
+			participated = true
+			entry.Points[index[race.RaceName]] += data.NetPoints()
+			entry.Total += data.NetPoints()
+			if data.Position == 1 {
+				entry.Wins++
+			}
+			if data.Position > 0 && (entry.BestFinish == 0 || data.Position < entry.BestFinish) {
+				entry.BestFinish = data.Position
+			}
+		}
+		if participated {
+			rules.apply(&entry)
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Counted != b.Counted {
+			return a.Counted > b.Counted
+		}
+		if a.Wins != b.Wins {
+			return a.Wins > b.Wins
+		}
+		if a.BestFinish != b.BestFinish {
+			// a car that never finished ranks behind one that did
+			if a.BestFinish == 0 || b.BestFinish == 0 {
+				return b.BestFinish == 0
+			}
+			return a.BestFinish < b.BestFinish
+		}
+		return a.CarID < b.CarID
+	})
+
+	for i := range entries {
+		entries[i].Position = i + 1
+		if prev, ok := previous[entries[i].CarID]; ok {
+			entries[i].RelPos = prev - entries[i].Position
+		}
+	}
+	return entries
+}
+
+// UpdateLeaderboard rebuilds the leaderboard of every class (or age group when no classes
+// are defined) and the overall one, and pushes each car's overall standing to the live data.
+// Relative positions are computed against the group's baseline, the leaderboard before the last race finished.
+func (a *AllData) UpdateLeaderboard() error {
+	races := make([]Race, 0, len(a.Races))
+	for _, race := range a.Races {
+		races = append(races, race)
+	}
+
+	groups := map[string][]Parameters{}
+	groupRaces := map[string][]Race{}
+	var all []Parameters
+	for id, class := range a.Classes {
+		groups[id] = []Parameters{}
+		for _, race := range races {
+			if class.CountsRace(race.RaceName) {
+				groupRaces[id] = append(groupRaces[id], race)
+			}
+		}
+	}
+	for _, car := range a.CarMap {
+		all = append(all, car.Params)
+		if len(a.Classes) == 0 {
+			groups[car.Params.AgeGroup] = append(groups[car.Params.AgeGroup], car.Params)
+			groupRaces[car.Params.AgeGroup] = races
+		} else if id, ok := a.ClassID(car.Params.AgeGroup); ok {
+			groups[id] = append(groups[id], car.Params)
+		}
+	}
+	groups[overallLeaderboard] = all
+	groupRaces[overallLeaderboard] = races
+
+	leaderboards := make(map[string][]LeaderboardEntry, len(groups))
+	for group, cars := range groups {
+		baseline, ok := a.leaderboardBaseline(group)
+		if !ok {
+			baseline = a.Leaderboards[group]
+		}
+		leaderboards[group] = BuildLeaderboard(cars, groupRaces[group], baseline, a.Settings.Championship)
+		if !reflect.DeepEqual(leaderboards[group], a.Leaderboards[group]) {
+			Publish("leaderboard", leaderboards[group], leaderboardTopic(group))
+		}
+	}
+	a.Leaderboards = leaderboards
+
+	for _, entry := range a.Leaderboards[overallLeaderboard] {
+		a.UpdateLiveDataCarPositionCategory(entry.CarID, entry.Categories, entry.Points, entry.Position)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package master
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildLeaderboard(t *testing.T) {
+	cars := []Parameters{{CarID: "1"}, {CarID: "2"}, {CarID: "3"}}
+
+	tests := []struct {
+		name      string
+		races     []Race
+		baseline  []LeaderboardEntry
+		rules     ChampionshipRules
+		order     []string
+		relPos    []int
+		totals    []int
+		counted   []float64
+		dropped   [][]string
+		categs    [][]string
+		catPoints [][]int
+	}{
+		{
+			name: "sorted by total points",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 5}, "2": {Points: 10}, "3": {Points: 8}}},
+			},
+			order:  []string{"2", "3", "1"},
+			relPos: []int{0, 0, 0},
+			totals: []int{10, 8, 5},
+		},
+		{
+			name: "laps of the same race are summed into one category",
+			races: []Race{
+				{RaceName: "B", Lap: 2, RaceData: map[string]RaceData{"1": {Points: 3}}},
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 1}}},
+				{RaceName: "B", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 4}}},
+			},
+			order:     []string{"1"},
+			relPos:    []int{0},
+			totals:    []int{8},
+			categs:    [][]string{{"A", "B"}},
+			catPoints: [][]int{{1, 7}},
+		},
+		{
+			name: "negative points count",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 6}, "2": {Points: 5}}},
+				{RaceName: "B", Lap: 1, RaceData: map[string]RaceData{"1": {Points: -3}}},
+			},
+			order:  []string{"2", "1"},
+			relPos: []int{0, 0},
+			totals: []int{5, 3},
+		},
+		{
+			name: "tie broken by wins",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 6, Position: 2}, "2": {Points: 10, Position: 1}}},
+				{RaceName: "B", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 10, Position: 1}, "2": {Points: 6, Position: 2}}},
+				{RaceName: "C", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 10, Position: 1}, "2": {Points: 10, Position: 3}}},
+			},
+			order:  []string{"1", "2"},
+			relPos: []int{0, 0},
+			totals: []int{26, 26},
+		},
+		{
+			name: "tie broken by best finish",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 5, Position: 3}, "2": {Points: 5, Position: 2}}},
+			},
+			order:  []string{"2", "1"},
+			relPos: []int{0, 0},
+			totals: []int{5, 5},
+		},
+		{
+			name: "tie broken by car ID",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"3": {Points: 5}, "2": {Points: 5}, "1": {Points: 5}}},
+			},
+			order:  []string{"1", "2", "3"},
+			relPos: []int{0, 0, 0},
+			totals: []int{5, 5, 5},
+		},
+		{
+			name: "relative position against baseline",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 1}, "2": {Points: 3}, "3": {Points: 2}}},
+			},
+			baseline: []LeaderboardEntry{{CarID: "1", Position: 1}, {CarID: "2", Position: 3}},
+			order:    []string{"2", "3", "1"},
+			relPos:   []int{2, 0, -2},
+			totals:   []int{3, 2, 1},
+		},
+		{
+			name: "category weights",
+			races: []Race{
+				{RaceName: "Endurance", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 6}, "2": {Points: 10}}},
+				{RaceName: "Sprint", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 10}, "2": {Points: 4}}},
+			},
+			rules:   ChampionshipRules{Weights: map[string]float64{"Endurance": 2}},
+			order:   []string{"2", "1"},
+			relPos:  []int{0, 0},
+			totals:  []int{14, 16},
+			counted: []float64{24, 22},
+		},
+		{
+			name: "drop worst result",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 10}, "2": {Points: 8}}},
+				{RaceName: "B", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 0}, "2": {Points: 8}}},
+				{RaceName: "C", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 10}, "2": {Points: 6}}},
+			},
+			rules:   ChampionshipRules{DropWorst: 1},
+			order:   []string{"1", "2"},
+			relPos:  []int{0, 0},
+			totals:  []int{20, 22},
+			counted: []float64{20, 16},
+			dropped: [][]string{{"B"}, {"C"}},
+		},
+		{
+			name: "a missed race is the one dropped",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 12}, "2": {Points: 8}}},
+				{RaceName: "B", Lap: 1, RaceData: map[string]RaceData{"2": {Points: 6}}},
+				{RaceName: "C", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 4}, "2": {Points: 5}}},
+			},
+			rules:     ChampionshipRules{DropWorst: 1},
+			order:     []string{"1", "2"},
+			relPos:    []int{0, 0},
+			totals:    []int{16, 19},
+			counted:   []float64{16, 14},
+			dropped:   [][]string{{"B"}, {"C"}},
+			categs:    [][]string{{"A", "B", "C"}, {"A", "B", "C"}},
+			catPoints: [][]int{{12, 0, 4}, {8, 6, 5}},
+		},
+		{
+			name: "best of with mandatory race",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 1}}},
+				{RaceName: "B", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 5}}},
+				{RaceName: "C", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 8}}},
+			},
+			rules:   ChampionshipRules{BestOf: 2, Mandatory: []string{"A"}},
+			order:   []string{"1"},
+			relPos:  []int{0},
+			totals:  []int{14},
+			counted: []float64{9},
+			dropped: [][]string{{"B"}},
+		},
+		{
+			name: "cars without race data are left out",
+			races: []Race{
+				{RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"2": {Points: 0}}},
+			},
+			order:  []string{"2"},
+			relPos: []int{0},
+			totals: []int{0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries := BuildLeaderboard(cars, tt.races, tt.baseline, tt.rules)
+
+			var order []string
+			var relPos, totals []int
+			var counted []float64
+			for i, e := range entries {
+				assert.Equal(t, i+1, e.Position)
+				order = append(order, e.CarID)
+				relPos = append(relPos, e.RelPos)
+				totals = append(totals, e.Total)
+				counted = append(counted, e.Counted)
+			}
+			assert.Equal(t, tt.order, order)
+			assert.Equal(t, tt.relPos, relPos)
+			assert.Equal(t, tt.totals, totals)
+			if tt.counted != nil {
+				assert.Equal(t, tt.counted, counted)
+			}
+			for i := range tt.dropped {
+				assert.Equal(t, tt.dropped[i], entries[i].Dropped)
+			}
+			for i := range tt.categs {
+				assert.Equal(t, tt.categs[i], entries[i].Categories)
+				assert.Equal(t, tt.catPoints[i], entries[i].Points)
+			}
+
+			// The result must not depend on the order races are passed in
+			reversed := make([]Race, len(tt.races))
+			for i, r := range tt.races {
+				reversed[len(tt.races)-1-i] = r
+			}
+			assert.Equal(t, entries, BuildLeaderboard(cars, reversed, tt.baseline, tt.rules))
+		})
+	}
+}
+
+func TestLeaderboardBaseline(t *testing.T) {
+	a := AllData{
+		CarMap: map[string]Car{"1": {Params: Parameters{CarID: "1", AgeGroup: "Junior"}}, "2": {Params: Parameters{CarID: "2", AgeGroup: "Junior"}}},
+		Races: map[string]Race{
+			"A_1": {RaceName: "A", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 10}, "2": {Points: 5}}},
+			"B_1": {RaceName: "B", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 0}, "2": {Points: 20}}},
+		},
+		Classes:  map[string]Class{"junior": {ID: "junior"}},
+		LiveData: map[string]LiveDataInstance{},
+	}
+	relPos := func() map[string]int {
+		entries, err := a.GetLeaderboard("JUNIOR")
+		assert.NoError(t, err)
+		pos := map[string]int{}
+		for _, e := range entries {
+			pos[e.CarID] = e.RelPos
+		}
+		return pos
+	}
+
+	delete(a.Races, "B_1")
+	assert.NoError(t, a.UpdateLeaderboard())
+	a.Races["B_1"] = Race{RaceName: "B", Lap: 1, RaceData: map[string]RaceData{"1": {Points: 0}, "2": {Points: 20}}}
+	assert.NoError(t, a.RaceFinish(Race{RaceName: "B", Lap: 1}, nil))
+	assert.Equal(t, map[string]int{"1": -1, "2": 1}, relPos())
+
+	// Recomputing after the finish, e.g. for a settings change, keeps the movement of the race
+	assert.NoError(t, a.UpdateLeaderboard())
+	assert.Equal(t, map[string]int{"1": -1, "2": 1}, relPos())
+
+	assert.NoError(t, a.DeleteLeaderboard("Junior"))
+	assert.NoError(t, a.UpdateLeaderboard())
+	assert.Equal(t, map[string]int{"1": 0, "2": 0}, relPos())
+
+	assert.Len(t, a.GetLeaderboardSnapshots("JUNIOR"), 1, "after B, baselines are kept out of the history")
+	_, err := a.GetLeaderboardSnapshot("Junior", 1)
+	assert.NoError(t, err)
+	_, err = a.DiffLeaderboardSnapshots("Junior", 1, 0)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,252 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const psuHardwareMaxCurrent = 20 // A, the most the car PSUs can deliver
+
+// Limit formulas compute the current before caps and bounds are applied
+const (
+	FormulaMass  = "mass"  // Mass × PowerCoef / U, the default
+	FormulaPower = "power" // MaxPower / U
+	FormulaFixed = "fixed" // FixedCurrent
+)
+
+// limitFormulas are the pluggable current formulas, keyed by LimitRules.Formula
+var limitFormulas = map[string]func(in LimitInput, rules LimitRules, u float64) float64{
+	FormulaMass: func(in LimitInput, rules LimitRules, u float64) float64 {
+		coef := rules.PowerCoef
+		if coef == 0 {
+			coef = in.Settings.RaceCoeficient
+		}
+		return in.Params.Mass * coef / u
+	},
+	FormulaPower: func(in LimitInput, rules LimitRules, u float64) float64 {
+		return rules.MaxPower / u
+	},
+	FormulaFixed: func(in LimitInput, rules LimitRules, u float64) float64 {
+		return rules.FixedCurrent
+	},
+}
+
+// LimitRules configure how a car's PSU limits are computed. Settings hold the default rules, a class can replace them.
+type LimitRules struct {
+	Formula      string  `json:"Formula"`      // mass (default), power or fixed
+	PowerCoef    float64 `json:"PowerCoef"`    // W/kg for the mass formula, 0 to use the settings' PowerCoef
+	FixedCurrent float64 `json:"FixedCurrent"` // A for the fixed formula
+	MaxPower     float64 `json:"MaxPower"`     // W, caps U × I, 0 for no cap
+	MaxVoltage   float64 `json:"MaxVoltage"`   // V, 0 for no cap
+	MinCurrent   float64 `json:"MinCurrent"`   // A
+	MaxCurrent   float64 `json:"MaxCurrent"`   // A, 0 for the PSU hardware maximum
+	RampUp       float64 `json:"RampUp"`       // s after the race start during which the current rises to the full limit, 0 for none
+	RampStart    float64 `json:"RampStart"`    // fraction of the current at the start of the ramp
+}
+
+// LimitInput is everything a limit is computed from
+type LimitInput struct {
+	Params    Parameters
+	Class     *Class // nil without classes
+	Race      *Race  // nil when the car is not racing
+	StartedAt time.Time
+	Settings  Settings
+	Now       time.Time
+}
+
+type Limits struct {
+	CarID   string   `json:"ID"`
+	Class   string   `json:"Class,omitempty"`
+	Race    string   `json:"Race,omitempty"`
+	Formula string   `json:"Formula"`
+	U       float64  `json:"U"`                // V
+	I       float64  `json:"I"`                // A
+	P       float64  `json:"P"`                // W, U × I
+	Ramp    float64  `json:"Ramp"`             // fraction of the full current allowed by the ramp-up, 1 when not ramping
+	Capped  []string `json:"Capped,omitempty"` // caps and bounds that changed the formula's result
+}
+
+func (r LimitRules) Validate() error {
+	if r.Formula != "" {
+		if _, ok := limitFormulas[r.Formula]; !ok {
+			return fmt.Errorf("unknown limit formula '%s'", r.Formula)
+		}
+	}
+	if r.PowerCoef < 0 || r.FixedCurrent < 0 || r.MaxPower < 0 || r.MaxVoltage < 0 || r.MinCurrent < 0 || r.MaxCurrent < 0 || r.RampUp < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	if r.Formula == FormulaPower && r.MaxPower == 0 {
+		return fmt.Errorf("the power formula needs MaxPower")
+	}
+	if r.MaxCurrent > 0 && r.MinCurrent > r.MaxCurrent {
+		return fmt.Errorf("minimum current is above maximum current")
+	}
+	if r.MinCurrent > psuHardwareMaxCurrent {
+		return fmt.Errorf("minimum current is above the PSU maximum of %d A", psuHardwareMaxCurrent)
+	}
+	if r.RampStart < 0 || r.RampStart > 1 {
+		return fmt.Errorf("ramp start must be a fraction between 0 and 1")
+	}
+	return nil
+}
+
+// ComputeLimits applies the rules to the input: voltage caps, the formula, the power cap, the ramp-up and the current bounds in that order
+func ComputeLimits(in LimitInput, rules LimitRules) (Limits, error) {
+	l := Limits{CarID: in.Params.CarID, Formula: rules.Formula, Ramp: 1}
+	if l.Formula == "" {
+		l.Formula = FormulaMass
+	}
+	if in.Class != nil {
+		l.Class = in.Class.ID
+	}
+	if in.Race != nil {
+		l.Race = in.Race.RaceName
+	}
+
+	u := in.Params.SetVoltage
+	if u <= 0 {
+		return l, fmt.Errorf("SetVoltage for car %s is zero or negative", in.Params.CarID)
+	}
+	if rules.MaxVoltage > 0 && u > rules.MaxVoltage {
+		u = rules.MaxVoltage
+		l.Capped = append(l.Capped, "MaxVoltage")
+	}
+	// Registration rejects cars above the class cap, but a car registered before the class was tightened
+	// keeps racing until it registers again, UpdateClasses only warns about it
+	if in.Class != nil && in.Class.MaxVoltage > 0 && u > in.Class.MaxVoltage {
+		u = in.Class.MaxVoltage
+		l.Capped = append(l.Capped, "ClassMaxVoltage")
+	}
+
+	i := limitFormulas[l.Formula](in, rules, u)
+	if rules.MaxPower > 0 && u*i > rules.MaxPower {
+		i = rules.MaxPower / u
+		l.Capped = append(l.Capped, "MaxPower")
+	}
+	if in.Race != nil && rules.RampUp > 0 && !in.StartedAt.IsZero() {
+		if elapsed := in.Now.Sub(in.StartedAt).Seconds(); elapsed < rules.RampUp {
+			if elapsed < 0 {
+				elapsed = 0
+			}
+			l.Ramp = rules.RampStart + (1-rules.RampStart)*elapsed/rules.RampUp
+			i *= l.Ramp
+		}
+	}
+
+	maxI := float64(psuHardwareMaxCurrent)
+	if rules.MaxCurrent > 0 && rules.MaxCurrent < maxI {
+		maxI = rules.MaxCurrent
+	}
+	if i > maxI {
+		i = maxI
+		l.Capped = append(l.Capped, "MaxCurrent")
+	}
+	if i < rules.MinCurrent {
+		i = rules.MinCurrent
+		l.Capped = append(l.Capped, "MinCurrent")
+	}
+
+	l.U, l.I, l.P = u, i, u*i
+	return l, nil
+}
+
+// capLimits holds limits that replace the computed ones, set by a command, to the caps ComputeLimits applies:
+// the car's set voltage, the voltage caps, the power cap and the current maximum
+func capLimits(u, i, setVoltage float64, rules LimitRules, class *Class) (float64, float64) {
+	for _, maxU := range []float64{setVoltage, rules.MaxVoltage} {
+		if maxU > 0 && u > maxU {
+			u = maxU
+		}
+	}
+	if class != nil && class.MaxVoltage > 0 && u > class.MaxVoltage {
+		u = class.MaxVoltage
+	}
+	if rules.MaxPower > 0 && u > 0 && u*i > rules.MaxPower {
+		i = rules.MaxPower / u
+	}
+	maxI := float64(psuHardwareMaxCurrent)
+	if rules.MaxCurrent > 0 && rules.MaxCurrent < maxI {
+		maxI = rules.MaxCurrent
+	}
+	return u, min(i, maxI)
+}
+
+// LimitsPreview shows the computed limits next to the setpoint the car currently has
+type LimitsPreview struct {
+	Limits
+	PublishedU float64 `json:"Published U"`
+	PublishedI float64 `json:"Published I"`
+	OutputOn   bool    `json:"Output on"`
+	Applied    bool    `json:"Applied"`            // the computed limits, after the speed governor, are what was last published
+	Governor   string  `json:"Governor,omitempty"` // speed governor intervention in force
+}
+
+// PreviewLimits computes the car's limits without publishing them
+func (srv *Service) PreviewLimits(carID string) (LimitsPreview, error) {
+	l, err := srv.AllData.CarLimits(carID)
+	if err != nil {
+		return LimitsPreview{}, err
+	}
+	p := LimitsPreview{Limits: l, Governor: srv.governor.action(carID)}
+	if sp, ok := srv.psuPub.published(carID); ok {
+		p.PublishedU, p.PublishedI, p.OutputOn = float64(sp.U), float64(sp.I), sp.Status != 0
+		want := srv.setpoint(carID, l)
+		p.Applied = sp.U == want.U && sp.I == want.I
+	}
+	return p, nil
+}
+
+// CarLimits gathers the car's class, current race and the applicable rules and computes its PSU limits
+func (a *AllData) CarLimits(carID string) (Limits, error) {
+	car, ok := a.CarMap[carID]
+	if !ok {
+		return Limits{}, fmt.Errorf("car with ID '%s' not found", carID)
+	}
+	in := LimitInput{Params: car.Params, Settings: a.Settings, Now: time.Now()}
+	var rules LimitRules
+	rules, in.Class = a.carRules(car)
+	if car.CurrentRace != nil {
+		race, ok := a.Races[raceKey(*car.CurrentRace)]
+		if !ok {
+			race = *car.CurrentRace
+		}
+		in.Race = &race
+		in.StartedAt = race.RaceData[carID].StartedAt
+	}
+	return ComputeLimits(in, rules)
+}
+
+// carRules returns the car's class, nil without one, and the limit rules that apply to it
+func (a *AllData) carRules(car Car) (LimitRules, *Class) {
+	id, ok := a.ClassID(car.Params.AgeGroup)
+	if !ok {
+		return a.Settings.Limits, nil
+	}
+	class := a.Classes[id]
+	if class.Limits != nil {
+		return *class.Limits, &class
+	}
+	return a.Settings.Limits, &class
+}
+
+// AllCarLimits computes the limits of every car, cars whose limits cannot be computed are left out with an error each
+func (a *AllData) AllCarLimits() (map[string]Limits, []error) {
+	ids := make([]string, 0, len(a.CarMap))
+	for id := range a.CarMap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	limits := make(map[string]Limits, len(ids))
+	var errs []error
+	for _, id := range ids {
+		l, err := a.CarLimits(id)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		limits[id] = l
+	}
+	return limits, errs
+}
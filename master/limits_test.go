@@ -0,0 +1,123 @@
+package master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComputeLimits(t *testing.T) {
+	now := time.Now()
+	params := Parameters{CarID: "1", SetVoltage: 24, Mass: 100}
+	settings := Settings{RaceCoeficient: 2.4}
+	race := &Race{RaceName: "A"}
+
+	tests := []struct {
+		name    string
+		in      LimitInput
+		rules   LimitRules
+		u       float64
+		i       float64
+		ramp    float64
+		capped  []string
+		wantErr bool
+	}{
+		{name: "mass formula from settings", in: LimitInput{Params: params, Settings: settings}, u: 24, i: 10, ramp: 1},
+		{name: "own power coefficient", in: LimitInput{Params: params, Settings: settings}, rules: LimitRules{PowerCoef: 1.2}, u: 24, i: 5, ramp: 1},
+		{name: "power formula", in: LimitInput{Params: params}, rules: LimitRules{Formula: FormulaPower, MaxPower: 120}, u: 24, i: 5, ramp: 1},
+		{name: "fixed formula", in: LimitInput{Params: params}, rules: LimitRules{Formula: FormulaFixed, FixedCurrent: 3}, u: 24, i: 3, ramp: 1},
+		{
+			name:   "power cap",
+			in:     LimitInput{Params: params, Settings: settings},
+			rules:  LimitRules{MaxPower: 120},
+			u:      24,
+			i:      5,
+			ramp:   1,
+			capped: []string{"MaxPower"},
+		},
+		{
+			name:   "class voltage cap",
+			in:     LimitInput{Params: params, Settings: settings, Class: &Class{ID: "junior", MaxVoltage: 12}},
+			u:      12,
+			i:      20,
+			ramp:   1,
+			capped: []string{"ClassMaxVoltage"},
+		},
+		{
+			name:   "hardware maximum",
+			in:     LimitInput{Params: params, Settings: Settings{RaceCoeficient: 10}},
+			u:      24,
+			i:      20,
+			ramp:   1,
+			capped: []string{"MaxCurrent"},
+		},
+		{
+			name:   "minimum current",
+			in:     LimitInput{Params: params},
+			rules:  LimitRules{MinCurrent: 1},
+			u:      24,
+			i:      1,
+			ramp:   1,
+			capped: []string{"MinCurrent"},
+		},
+		{
+			name:  "halfway through the ramp-up",
+			in:    LimitInput{Params: params, Settings: settings, Race: race, StartedAt: now.Add(-5 * time.Second), Now: now},
+			rules: LimitRules{RampUp: 10, RampStart: 0.2},
+			u:     24,
+			i:     6,
+			ramp:  0.6,
+		},
+		{
+			name:  "ramp-up over",
+			in:    LimitInput{Params: params, Settings: settings, Race: race, StartedAt: now.Add(-time.Minute), Now: now},
+			rules: LimitRules{RampUp: 10},
+			u:     24,
+			i:     10,
+			ramp:  1,
+		},
+		{name: "no voltage", in: LimitInput{Params: Parameters{CarID: "2"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := ComputeLimits(tt.in, tt.rules)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDelta(t, tt.u, l.U, 1e-9)
+			assert.InDelta(t, tt.i, l.I, 1e-9)
+			assert.InDelta(t, tt.ramp, l.Ramp, 1e-9)
+			assert.Equal(t, tt.capped, l.Capped)
+		})
+	}
+}
+
+func TestCapLimits(t *testing.T) {
+	class := &Class{ID: "junior", MaxVoltage: 12}
+	tests := []struct {
+		name  string
+		u, i  float64
+		rules LimitRules
+		class *Class
+		wantU float64
+		wantI float64
+	}{
+		{name: "within the caps", u: 20, i: 5, wantU: 20, wantI: 5},
+		{name: "set voltage", u: 100, i: 5, wantU: 24, wantI: 5},
+		{name: "class voltage", u: 20, i: 5, class: class, wantU: 12, wantI: 5},
+		{name: "power cap", u: 24, i: 10, rules: LimitRules{MaxPower: 120}, wantU: 24, wantI: 5},
+		{name: "current maximum", u: 24, i: 10, rules: LimitRules{MaxCurrent: 8}, wantU: 24, wantI: 8},
+		{name: "hardware maximum", u: 24, i: 30, wantU: 24, wantI: psuHardwareMaxCurrent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, i := capLimits(tt.u, tt.i, 24, tt.rules, tt.class)
+			assert.InDelta(t, tt.wantU, u, 1e-9)
+			assert.InDelta(t, tt.wantI, i, 1e-9)
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package master
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultLiveRate = 1.0  // Hz
+	maxLiveRate     = 10.0 // Hz
+)
+
+// Live data message types on the live topic. A client gets a snapshot when it subscribes, then deltas with
+// consecutive sequence numbers. On a gap it sends {"type":"resync"} and gets a new snapshot.
+const (
+	LiveSnapshot = "snapshot"
+	LiveDelta    = "delta"
+	LiveResync   = "resync"
+)
+
+// liveData is the data of snapshot and delta messages
+type liveData struct {
+	Seq     int                        `json:"seq"`  // the snapshot's seq is that of the last delta it includes
+	Cars    map[string]json.RawMessage `json:"cars"` // map of [carID], whole LiveDataInstance of the cars that changed
+	Removed []string                   `json:"removed,omitempty"`
+}
+
+type liveStream struct {
+	mutex sync.Mutex
+	seq   int
+	last  map[string]json.RawMessage // map of [carID], as last sent
+}
+
+func (srv *Service) liveInterval() time.Duration {
+	rate := srv.AllData.Settings.LiveRate
+	if rate <= 0 {
+		rate = defaultLiveRate
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+func validLiveRate(rate float64) error {
+	if rate < 0 || rate > maxLiveRate {
+		return fmt.Errorf("live rate must be between 0 and %.0f Hz", maxLiveRate)
+	}
+	return nil
+}
+
+// LiveDataByCar marshals each car's live data on its own
+func (a *AllData) LiveDataByCar() map[string]json.RawMessage {
+	a.LiveDataMutex.Lock()
+	defer a.LiveDataMutex.Unlock()
+
+	cars := make(map[string]json.RawMessage, len(a.LiveData))
+	for carID, dat := range a.LiveData {
+		data, err := json.Marshal(dat)
+		if err != nil {
+			logrus.WithError(errors.Wrapf(err, "JSON car %s", carID)).Error("Error")
+			continue
+		}
+		cars[carID] = data
+	}
+	return cars
+}
+
+// delta compares the live data with what was last sent and returns the next delta when something changed.
+// The caller holds the lock.
+func (l *liveStream) delta(cars map[string]json.RawMessage) (liveData, bool) {
+	d := liveData{Cars: map[string]json.RawMessage{}}
+	for carID, data := range cars {
+		if !bytes.Equal(l.last[carID], data) {
+			d.Cars[carID] = data
+		}
+	}
+	for carID := range l.last {
+		if _, ok := cars[carID]; !ok {
+			d.Removed = append(d.Removed, carID)
+		}
+	}
+	if len(d.Cars) == 0 && len(d.Removed) == 0 {
+		return d, false
+	}
+	sort.Strings(d.Removed)
+	l.seq++
+	l.last = cars
+	d.Seq = l.seq
+	return d, true
+}
+
+// snapshot is everything last sent, the caller holds the lock
+func (l *liveStream) snapshot() liveData {
+	d := liveData{Seq: l.seq, Cars: l.last}
+	if d.Cars == nil {
+		d.Cars = map[string]json.RawMessage{}
+	}
+	return d
+}
+
+// sendSnapshot queues a snapshot for the session. It is held back for the next tick when the session is busy.
+func (srv *Service) sendSnapshot(s *Session) {
+	srv.live.mutex.Lock()
+	defer srv.live.mutex.Unlock()
+	srv.sendSnapshotLocked(s)
+}
+
+// sendSnapshotLocked is sendSnapshot for callers holding the live stream lock
+func (srv *Service) sendSnapshotLocked(s *Session) {
+	msg, err := envelope(LiveSnapshot, TopicLive, srv.live.snapshot())
+	if err != nil {
+		logrus.WithError(err).Error("Error")
+		return
+	}
+	s.resync.Store(!s.send(msg))
+}
@@ -0,0 +1,98 @@
+package master
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveStreamDelta(t *testing.T) {
+	type tick struct {
+		cars    map[string]string // live data by car ID as the ticker reads it
+		ok      bool
+		seq     int
+		changed []string
+		removed []string
+	}
+
+	tests := []struct {
+		name  string
+		ticks []tick
+	}{
+		{
+			name:  "nothing to send",
+			ticks: []tick{{cars: map[string]string{}}},
+		},
+		{
+			name: "first delta has every car",
+			ticks: []tick{
+				{cars: map[string]string{"1": `{"a":1}`, "2": `{"a":2}`}, ok: true, seq: 1, changed: []string{"1", "2"}},
+			},
+		},
+		{
+			name: "only changed cars, no message when nothing changed",
+			ticks: []tick{
+				{cars: map[string]string{"1": `{"a":1}`, "2": `{"a":2}`}, ok: true, seq: 1, changed: []string{"1", "2"}},
+				{cars: map[string]string{"1": `{"a":1}`, "2": `{"a":2}`}},
+				{cars: map[string]string{"1": `{"a":1}`, "2": `{"a":3}`}, ok: true, seq: 2, changed: []string{"2"}},
+			},
+		},
+		{
+			name: "removed cars are listed once",
+			ticks: []tick{
+				{cars: map[string]string{"1": `{"a":1}`, "2": `{"a":2}`, "3": `{"a":3}`}, ok: true, seq: 1, changed: []string{"1", "2", "3"}},
+				{cars: map[string]string{"2": `{"a":2}`}, ok: true, seq: 2, removed: []string{"1", "3"}},
+				{cars: map[string]string{"2": `{"a":2}`}},
+			},
+		},
+		{
+			name: "a car added back is sent whole",
+			ticks: []tick{
+				{cars: map[string]string{"1": `{"a":1}`}, ok: true, seq: 1, changed: []string{"1"}},
+				{cars: map[string]string{}, ok: true, seq: 2, removed: []string{"1"}},
+				{cars: map[string]string{"1": `{"a":1}`}, ok: true, seq: 3, changed: []string{"1"}},
+			},
+		},
+		{
+			name: "change and removal in one delta",
+			ticks: []tick{
+				{cars: map[string]string{"1": `{"a":1}`, "2": `{"a":2}`}, ok: true, seq: 1, changed: []string{"1", "2"}},
+				{cars: map[string]string{"1": `{"a":4}`}, ok: true, seq: 2, changed: []string{"1"}, removed: []string{"2"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l liveStream
+			for i, tk := range tt.ticks {
+				cars := map[string]json.RawMessage{}
+				for carID, data := range tk.cars {
+					cars[carID] = json.RawMessage(data)
+				}
+
+				d, ok := l.delta(cars)
+				assert.Equal(t, tk.ok, ok, "tick %d", i)
+				if !ok {
+					continue
+				}
+				assert.Equal(t, tk.seq, d.Seq, "tick %d", i)
+				changed := []string{}
+				for carID, data := range d.Cars {
+					changed = append(changed, carID)
+					assert.JSONEq(t, tk.cars[carID], string(data))
+				}
+				sort.Strings(changed)
+				assert.Equal(t, append([]string{}, tk.changed...), changed, "tick %d", i)
+				assert.Equal(t, tk.removed, d.Removed, "tick %d", i)
+
+				// A snapshot taken now has everything sent so far and the seq of this delta
+				s := l.snapshot()
+				assert.Equal(t, d.Seq, s.Seq)
+				assert.Len(t, s.Cars, len(tk.cars))
+			}
+		})
+	}
+}
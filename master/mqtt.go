@@ -151,4 +151,12 @@ func (srv *Service) SubscribeMQTT(ctx context.Context) {
 	if err := token.Error(); err != nil {
 		logrus.WithError(errors.Wrap(err, "SUS")).Error("Error")
 	}
+
+	token = srv.mqtt.Subscribe("CMD_OUT/#", 1, func(c mqtt.Client, m mqtt.Message) {
+		srv.mqttReceiveCommandAck(ctx, c, m)
+	})
+	token.Wait()
+	if err := token.Error(); err != nil {
+		logrus.WithError(errors.Wrap(err, "CMD")).Error("Error")
+	}
 }
@@ -0,0 +1,254 @@
+package master
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Penalty types
+const (
+	PenaltyTime   = "time"   // seconds added to the race time
+	PenaltyEnergy = "energy" // Wh added to the used energy
+	PenaltyPoints = "points" // points deducted
+	PenaltyDSQ    = "dsq"    // disqualification from the race
+)
+
+type Penalty struct {
+	ID        int       `json:"ID"`
+	CarID     string    `json:"CarID"`
+	Type      string    `json:"Type"`
+	Value     float64   `json:"Value"` // seconds, Wh or whole points depending on the type, unused for dsq
+	Reason    string    `json:"Reason"`
+	Issuer    string    `json:"Issuer"`
+	IssuedAt  time.Time `json:"IssuedAt"`
+	Revoked   bool      `json:"Revoked"`
+	RevokedBy string    `json:"RevokedBy,omitempty"`
+	RevokedAt time.Time `json:"RevokedAt,omitempty"`
+	RaceName  string    `json:"RaceName,omitempty"` // set in the car's penalties across races
+	Lap       int       `json:"Lap,omitempty"`
+}
+
+func (p Penalty) Validate() error {
+	switch p.Type {
+	case PenaltyTime, PenaltyEnergy, PenaltyPoints:
+		if p.Value <= 0 {
+			return fmt.Errorf("%s penalty value must be positive", p.Type)
+		}
+		if p.Type == PenaltyPoints && p.Value != math.Trunc(p.Value) {
+			return fmt.Errorf("points penalty value must be a whole number")
+		}
+	case PenaltyDSQ:
+	default:
+		return fmt.Errorf("unknown penalty type '%s'", p.Type)
+	}
+	if p.CarID == "" {
+		return fmt.Errorf("penalty car ID must not be empty")
+	}
+	if p.Reason == "" || p.Issuer == "" {
+		return fmt.Errorf("penalty reason and issuer are required")
+	}
+	return nil
+}
+
+// ActivePenalties lists the penalties that have not been revoked
+func (d RaceData) ActivePenalties() []Penalty {
+	var active []Penalty
+	for _, p := range d.Penalties {
+		if !p.Revoked {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
+func (d RaceData) penaltySum(penaltyType string) float64 {
+	var sum float64
+	for _, p := range d.ActivePenalties() {
+		if p.Type == penaltyType {
+			sum += p.Value
+		}
+	}
+	return sum
+}
+
+func (d RaceData) Disqualified() bool {
+	for _, p := range d.ActivePenalties() {
+		if p.Type == PenaltyDSQ {
+			return true
+		}
+	}
+	return false
+}
+
+// PenalizedTime is the race time with time penalties added
+func (d RaceData) PenalizedTime() time.Duration {
+	return d.RaceTime + time.Duration(d.penaltySum(PenaltyTime)*float64(time.Second))
+}
+
+// PenalizedWh is the used energy with energy penalties added
+func (d RaceData) PenalizedWh() float64 {
+	return d.TotalWh + d.penaltySum(PenaltyEnergy)
+}
+
+// NetPoints are the points counted in the leaderboard, after point deductions. A disqualified car scores nothing.
+func (d RaceData) NetPoints() int {
+	if d.Disqualified() {
+		return 0
+	}
+	return d.Points - int(d.penaltySum(PenaltyPoints))
+}
+
+func (a *AllData) findRace(raceName string, lap int) (string, Race, error) {
+	key := raceName + "_" + strconv.Itoa(lap)
+	race, ok := a.Races[key]
+	if !ok {
+		return key, race, fmt.Errorf("race '%s' lap %d not found", raceName, lap)
+	}
+	return key, race, nil
+}
+
+func (a *AllData) GetPenalties(raceName string, lap int) ([]Penalty, error) {
+	_, race, err := a.findRace(raceName, lap)
+	if err != nil {
+		return nil, err
+	}
+	penalties := []Penalty{}
+	for _, data := range race.RaceData {
+		penalties = append(penalties, data.Penalties...)
+	}
+	sort.Slice(penalties, func(i, j int) bool { return penalties[i].ID < penalties[j].ID })
+	return penalties, nil
+}
+
+// AddPenalty records a penalty against a car in the race lap and re-ranks the race
+func (a *AllData) AddPenalty(raceName string, lap int, p Penalty) (Penalty, error) {
+	if err := p.Validate(); err != nil {
+		return p, err
+	}
+	key, race, err := a.findRace(raceName, lap)
+	if err != nil {
+		return p, err
+	}
+	data, ok := race.RaceData[p.CarID]
+	if !ok {
+		return p, fmt.Errorf("car '%s' has no data in race '%s' lap %d", p.CarID, raceName, lap)
+	}
+
+	// Penalty IDs are unique within the race lap
+	p.ID = 1
+	for _, d := range race.RaceData {
+		for _, existing := range d.Penalties {
+			if existing.ID >= p.ID {
+				p.ID = existing.ID + 1
+			}
+		}
+	}
+	p.IssuedAt = time.Now()
+	p.Revoked = false
+	data.Penalties = append(data.Penalties, p)
+	race.RaceData[p.CarID] = data
+	a.Races[key] = race
+
+	return p, a.penaltiesChanged(key, p.CarID)
+}
+
+// RevokePenalty marks the penalty as revoked, it stays on record
+func (a *AllData) RevokePenalty(raceName string, lap int, id int, revokedBy string) (Penalty, error) {
+	key, race, err := a.findRace(raceName, lap)
+	if err != nil {
+		return Penalty{}, err
+	}
+	for carID, data := range race.RaceData {
+		for i, p := range data.Penalties {
+			if p.ID != id {
+				continue
+			}
+			if p.Revoked {
+				return p, fmt.Errorf("penalty %d is already revoked", id)
+			}
+			p.Revoked = true
+			p.RevokedBy = revokedBy
+			p.RevokedAt = time.Now()
+			data.Penalties[i] = p
+			race.RaceData[carID] = data
+			a.Races[key] = race
+			return p, a.penaltiesChanged(key, carID)
+		}
+	}
+	return Penalty{}, fmt.Errorf("penalty %d not found in race '%s' lap %d", id, raceName, lap)
+}
+
+// CarPenalties lists the car's active penalties in every race, oldest first
+func (a *AllData) CarPenalties(carID string) []Penalty {
+	penalties := []Penalty{}
+	for _, race := range a.Races {
+		for _, p := range race.RaceData[carID].ActivePenalties() {
+			p.RaceName, p.Lap = race.RaceName, race.Lap
+			penalties = append(penalties, p)
+		}
+	}
+	sort.Slice(penalties, func(i, j int) bool { return penalties[i].IssuedAt.Before(penalties[j].IssuedAt) })
+	return penalties
+}
+
+func (a *AllData) penaltiesChanged(key, carID string) error {
+	a.UpdateLiveDataCarPenalties(carID, a.CarPenalties(carID))
+	if err := a.UpdatePositions(key); err != nil {
+		return err
+	}
+	if err := a.ScoreRace(key); err != nil {
+		return err
+	}
+	return a.UpdateLeaderboard()
+}
+
+// migrateScoringPenalties turns the points deductions of old files, kept in the race's scoring rules, into
+// points penalties. Computed points had the deduction subtracted already, it is added back so that it is not
+// deducted twice.
+func (a *AllData) migrateScoringPenalties() {
+	for key, race := range a.Races {
+		if len(race.Scoring.Penalties) == 0 {
+			continue
+		}
+		carIDs := make([]string, 0, len(race.Scoring.Penalties))
+		for carID := range race.Scoring.Penalties {
+			carIDs = append(carIDs, carID)
+		}
+		sort.Strings(carIDs)
+
+		id := 0
+		for _, d := range race.RaceData {
+			for _, p := range d.Penalties {
+				id = max(id, p.ID)
+			}
+		}
+		for _, carID := range carIDs {
+			points := race.Scoring.Penalties[carID]
+			data, ok := race.RaceData[carID]
+			if !ok || points <= 0 {
+				logrus.Warnf("Race %s: dropped scoring penalty of %d points for car %s", key, points, carID)
+				continue
+			}
+			if len(race.Scoring.PointsTable) > 0 {
+				data.AutoPoints += points
+				if !data.PointsOverride {
+					data.Points += points
+				}
+			}
+			id++
+			data.Penalties = append(data.Penalties, Penalty{
+				ID: id, CarID: carID, Type: PenaltyPoints, Value: float64(points),
+				Reason: "Points deduction from the scoring rules", Issuer: "migration", IssuedAt: a.LastSave,
+			})
+			race.RaceData[carID] = data
+		}
+		race.Scoring.Penalties = nil
+		a.Races[key] = race
+		logrus.Infof("Race %s: scoring penalties migrated to points penalties", key)
+	}
+}
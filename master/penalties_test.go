@@ -0,0 +1,35 @@
+package master
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateScoringPenalties(t *testing.T) {
+	a := AllData{Races: map[string]Race{
+		"A_1": {
+			RaceName: "A", Lap: 1,
+			Scoring: ScoringRules{PointsTable: []int{10, 8}, Penalties: map[string]int{"1": 3, "9": 2}},
+			RaceData: map[string]RaceData{
+				"1": {Points: 7, AutoPoints: 7, Penalties: []Penalty{{ID: 4, CarID: "1", Type: PenaltyTime, Value: 5}}},
+				"2": {Points: 8, AutoPoints: 8},
+			},
+		},
+		"B_1": {
+			RaceName: "B", Lap: 1,
+			Scoring:  ScoringRules{Penalties: map[string]int{"2": 1}}, // no points table, the deduction was never applied
+			RaceData: map[string]RaceData{"2": {Points: 6}},
+		},
+	}}
+	a.migrateScoringPenalties()
+
+	d := a.Races["A_1"].RaceData["1"]
+	assert.Equal(t, 10, d.Points, "the deduction is added back")
+	assert.Equal(t, 7, d.NetPoints(), "and deducted once as a penalty")
+	assert.Equal(t, 5, d.Penalties[1].ID)
+	assert.Equal(t, PenaltyPoints, d.Penalties[1].Type)
+	assert.Equal(t, 8, a.Races["A_1"].RaceData["2"].NetPoints())
+	assert.Nil(t, a.Races["A_1"].Scoring.Penalties)
+	assert.Equal(t, 5, a.Races["B_1"].RaceData["2"].NetPoints())
+}
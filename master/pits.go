@@ -0,0 +1,213 @@
+package master
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Pit event sources
+const (
+	PitSourceAPI = "api"
+	PitSourceGPS = "gps"
+)
+
+type PitRules struct {
+	MinStopTime    float64 `json:"MinStopTime"`    // s, shorter stops get the shortfall as a time penalty, 0 for no minimum
+	ExcludePitTime bool    `json:"ExcludePitTime"` // stop time is not counted in RaceTime
+}
+
+type PitStop struct {
+	In       time.Time     `json:"In"`
+	Out      time.Time     `json:"Out,omitempty"` // zero while the car is in the pit
+	Duration time.Duration `json:"Duration"`
+	Source   string        `json:"Source"` // api or gps, of the pit-in
+	Short    bool          `json:"Short"`  // shorter than the race's minimum stop time
+}
+
+// Stint is the part of the race driven by one driver
+type Stint struct {
+	Driver string    `json:"Driver"`
+	Start  time.Time `json:"Start"`
+	End    time.Time `json:"End,omitempty"` // zero for the current stint
+}
+
+type pitLaneState struct {
+	seen   bool // at least one GPS fix since the start
+	inside bool
+}
+
+// PitInstance is the body of the pit-in and pit-out requests
+type PitInstance struct {
+	Driver string `json:"Driver"` // driver taking over, empty to keep the current one
+}
+
+func (p PitRules) Validate() error {
+	if p.MinStopTime < 0 {
+		return fmt.Errorf("minimum pit stop time must not be negative")
+	}
+	return nil
+}
+
+// InPit tells if the car has entered the pit and not left it yet
+func (d RaceData) InPit() bool {
+	return len(d.PitStops) > 0 && d.PitStops[len(d.PitStops)-1].Out.IsZero()
+}
+
+// Driver is the driver of the current stint
+func (d RaceData) Driver() string {
+	if len(d.Stints) == 0 {
+		return ""
+	}
+	return d.Stints[len(d.Stints)-1].Driver
+}
+
+// PitTime is the time spent in completed pit stops
+func (d RaceData) PitTime() time.Duration {
+	var total time.Duration
+	for _, p := range d.PitStops {
+		total += p.Duration
+	}
+	return total
+}
+
+func (a *AllData) carRace(carID string) (string, Race, RaceData, error) {
+	car, ok := a.CarMap[carID]
+	if !ok {
+		return "", Race{}, RaceData{}, fmt.Errorf("car with ID '%s' not found", carID)
+	}
+	if car.CurrentRace == nil {
+		return "", Race{}, RaceData{}, fmt.Errorf("car '%s' is not in any race", carID)
+	}
+	key := raceKey(*car.CurrentRace)
+	race, ok := a.Races[key]
+	if !ok {
+		race = *car.CurrentRace
+	}
+	data, ok := race.RaceData[carID]
+	if !ok || !data.RaceMode {
+		return key, race, data, fmt.Errorf("car '%s' is not racing", carID)
+	}
+	return key, race, data, nil
+}
+
+// PitIn records the car entering the pit. A driver given here takes over when the car leaves the pit.
+func (a *AllData) PitIn(carID string, p PitInstance, source string, at time.Time) error {
+	_, race, data, err := a.carRace(carID)
+	if err != nil {
+		return err
+	}
+	if data.InPit() {
+		return fmt.Errorf("car '%s' is already in the pit", carID)
+	}
+	data.PitStops = append(data.PitStops, PitStop{In: at, Source: source})
+	data.nextDriver = p.Driver
+	race.RaceData[carID] = data
+
+	logrus.Infof("Car %s pit in (%s)", carID, source)
+	a.UpdateLiveDataCarPit(carID, data)
+	return nil
+}
+
+// PitOut records the car leaving the pit, starts a new stint on a driver change and enforces the minimum stop time
+func (a *AllData) PitOut(carID string, p PitInstance, at time.Time) error {
+	_, race, data, err := a.carRace(carID)
+	if err != nil {
+		return err
+	}
+	if !data.InPit() {
+		return fmt.Errorf("car '%s' is not in the pit", carID)
+	}
+	stop := race.endPitStop(&data, at)
+
+	driver := p.Driver
+	if driver == "" {
+		driver = data.nextDriver
+	}
+	if driver != "" && driver != data.Driver() {
+		if n := len(data.Stints); n > 0 {
+			data.Stints[n-1].End = stop.In
+		}
+		data.Stints = append(data.Stints, Stint{Driver: driver, Start: at})
+	}
+	data.nextDriver = ""
+	race.RaceData[carID] = data
+
+	logrus.Infof("Car %s pit out after %s", carID, stop.Duration)
+	a.UpdateLiveDataCarPit(carID, data)
+
+	// The car is out of the pit whatever happens to the penalty, failing here would make a retry fail too
+	if stop.Short {
+		minStop := time.Duration(race.Pit.MinStopTime * float64(time.Second))
+		_, err := a.AddPenalty(race.RaceName, race.Lap, Penalty{
+			CarID:  carID,
+			Type:   PenaltyTime,
+			Value:  (minStop - stop.Duration).Seconds(),
+			Reason: fmt.Sprintf("pit stop %d shorter than %.0f s", len(data.PitStops), race.Pit.MinStopTime),
+			Issuer: "race control",
+		})
+		if err != nil {
+			logrus.WithError(err).Errorf("Pit stop penalty for car %s", carID)
+		}
+	}
+	return nil
+}
+
+// endPitStop closes the car's open pit stop and takes it out of the race time when the race excludes pit time
+func (race Race) endPitStop(data *RaceData, at time.Time) PitStop {
+	stop := data.PitStops[len(data.PitStops)-1]
+	stop.Out = at
+	stop.Duration = at.Sub(stop.In)
+	stop.Short = stop.Duration < time.Duration(race.Pit.MinStopTime*float64(time.Second))
+	data.PitStops[len(data.PitStops)-1] = stop
+	if race.Pit.ExcludePitTime {
+		data.RaceTime -= stop.Duration
+		if data.RaceTime < 0 {
+			data.RaceTime = 0
+		}
+	}
+	return stop
+}
+
+// pointInPolygon uses ray casting on the plain coordinates, which is fine for a pit lane sized area
+func pointInPolygon(p GeoPoint, polygon []GeoPoint) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a.Lat > p.Lat) != (b.Lat > p.Lat) &&
+			p.Lon < (b.Lon-a.Lon)*(p.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// TrackPitLane triggers pit-in and pit-out when the car's GPS position enters or leaves the track's pit lane
+func (a *AllData) TrackPitLane(carID string, pos GeoPoint, at time.Time) error {
+	_, race, data, err := a.carRace(carID)
+	if err != nil || race.TrackID == "" {
+		return nil
+	}
+	track, ok := a.Tracks[race.TrackID]
+	if !ok || len(track.PitLane) < 3 {
+		return nil
+	}
+
+	// Only a change of the GPS side triggers an event, so a stop entered through the API
+	// is not ended by a position that lags behind
+	inside := pointInPolygon(pos, track.PitLane)
+	prev := data.pitLane
+	data.pitLane = pitLaneState{seen: true, inside: inside}
+	race.RaceData[carID] = data
+	if !prev.seen || inside == prev.inside {
+		return nil
+	}
+	switch {
+	case inside && !data.InPit():
+		return a.PitIn(carID, PitInstance{}, PitSourceGPS, at)
+	case !inside && data.InPit():
+		return a.PitOut(carID, PitInstance{}, at)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PSU compliance states
+const (
+	PSUPending  = "pending"  // setpoint sent, waiting for PSU_OUT to confirm it
+	PSUApplied  = "applied"  // PSU_OUT is within the setpoint
+	PSUMismatch = "mismatch" // PSU_OUT is above the setpoint after the grace period
+)
+
+const (
+	psuAckTimeout      = 3 * time.Second // time the car has to apply a new setpoint
+	psuRelTolerance    = 0.05            // 5 % above the setpoint is measurement noise
+	psuVoltageAbsSlack = 0.2             // V
+	psuCurrentAbsSlack = 0.1             // A
+	psuOnVoltageBand   = 0.2             // an output on may sag this far below the setpoint under load
+	psuOffVoltage      = 1.0             // V, an output below this is off
+)
+
+// PSUState compares the last setpoint sent to a car with what its PSU reports back
+type PSUState struct {
+	CarID       string    `json:"ID"`
+	State       string    `json:"State"`
+	OverCurrent bool      `json:"OverCurrent"` // running above the allotted current limit
+	Reason      string    `json:"Reason,omitempty"`
+	CommandedU  float64   `json:"Commanded U"`
+	CommandedI  float64   `json:"Commanded I"`
+	OutputOn    bool      `json:"Output on"`
+	CommandedAt time.Time `json:"Commanded at"`
+	MeasuredU   float64   `json:"Measured U"`
+	MeasuredI   float64   `json:"Measured I"`
+	MeasuredAt  time.Time `json:"Measured at"`
+	Since       time.Time `json:"Since"` // when the state last changed
+}
+
+type psuMonitor struct {
+	mutex  sync.Mutex
+	states map[string]PSUState // map of [carID]
+}
+
+// measuredOn reports whether the last reading shows the output on: its voltage near the setpoint or current flowing
+func (s PSUState) measuredOn() bool {
+	return s.MeasuredU > psuOffVoltage &&
+		(s.MeasuredU >= s.CommandedU*(1-psuOnVoltageBand) || s.MeasuredI > psuCurrentAbsSlack)
+}
+
+// measuredOff reports whether the last reading shows the output off
+func (s PSUState) measuredOff() bool {
+	return s.MeasuredU <= psuOffVoltage && s.MeasuredI <= psuCurrentAbsSlack
+}
+
+// commanded records a setpoint sent to the car. Re-sending the same setpoint keeps the current state.
+func (m *psuMonitor) commanded(carID string, u, i float64, on bool, at time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.states == nil {
+		m.states = make(map[string]PSUState)
+	}
+	s := m.states[carID]
+	if s.State != "" && s.CommandedU == u && s.CommandedI == i && s.OutputOn == on {
+		return
+	}
+	s.CarID = carID
+	s.CommandedU, s.CommandedI, s.OutputOn, s.CommandedAt = u, i, on, at
+	s.State, s.Reason, s.OverCurrent, s.Since = PSUPending, "", false, at
+	m.states[carID] = s
+}
+
+// measured checks a PSU_OUT reading against the setpoint. It returns the new state and whether it changed.
+func (m *psuMonitor) measured(carID string, u, i float64, at time.Time) (PSUState, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.states[carID]
+	if !ok {
+		return s, false // nothing commanded yet
+	}
+	s.MeasuredU, s.MeasuredI, s.MeasuredAt = u, i, at
+
+	maxU, maxI := s.CommandedU, s.CommandedI
+	if !s.OutputOn {
+		maxI = 0
+	}
+	var reason string
+	overCurrent := i > maxI*(1+psuRelTolerance)+psuCurrentAbsSlack
+	switch {
+	case overCurrent:
+		reason = fmt.Sprintf("current %.2f A is above the limit of %.2f A", i, maxI)
+	case u > maxU*(1+psuRelTolerance)+psuVoltageAbsSlack:
+		reason = fmt.Sprintf("voltage %.2f V is above the setpoint of %.2f V", u, maxU)
+	}
+
+	state := PSUApplied
+	if reason != "" {
+		state = PSUMismatch
+		if s.State == PSUPending && at.Sub(s.CommandedAt) < psuAckTimeout {
+			state, overCurrent, reason = PSUPending, false, ""
+		}
+	}
+	changed := state != s.State || overCurrent != s.OverCurrent
+	if changed {
+		s.Since = at
+	}
+	s.State, s.OverCurrent, s.Reason = state, overCurrent, reason
+	m.states[carID] = s
+	return s, changed
+}
+
+func (m *psuMonitor) get(carID string) (PSUState, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	s, ok := m.states[carID]
+	return s, ok
+}
+
+func (m *psuMonitor) all() []PSUState {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	states := make([]PSUState, 0, len(m.states))
+	for _, s := range m.states {
+		states = append(states, s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].CarID < states[j].CarID })
+	return states
+}
+
+func (srv *Service) GetPSUState(carID string) (PSUState, error) {
+	s, ok := srv.psu.get(carID)
+	if !ok {
+		return s, fmt.Errorf("no PSU setpoint sent to car '%s' yet", carID)
+	}
+	return s, nil
+}
+
+func (srv *Service) GetPSUStates() []PSUState {
+	return srv.psu.all()
+}
+
+// checkPSUCompliance compares a PSU_OUT reading with the setpoint and alerts on state changes
+func (srv *Service) checkPSUCompliance(carID string, data dataPSU) {
+	s, changed := srv.psu.measured(carID, float64(data.Uop), float64(data.Iop), data.Time)
+	srv.ackPSUCommands(carID, s)
+	if !changed {
+		return
+	}
+	srv.AllData.UpdateLiveDataCarPSUState(carID, s.State, s.OverCurrent)
+	if s.State == PSUMismatch {
+		logrus.Warnf("Car %s PSU mismatch: %s", carID, s.Reason)
+	}
+
+	if s.State == PSUMismatch || s.OverCurrent {
+		Publish("psu", s, TopicAlerts, carTopic(carID))
+	} else {
+		Publish("psu", s, carTopic(carID))
+	}
+}
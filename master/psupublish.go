@@ -0,0 +1,234 @@
+package master
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPSURefresh = 30 * time.Second       // retained setpoints are re-published this often unless set in Settings
+	psuMinInterval    = 500 * time.Millisecond // per car, changes coming in faster are coalesced
+	psuTick           = 250 * time.Millisecond
+	psuMaxRetry       = 30 * time.Second // longest back-off between attempts for a car whose setpoint fails to send
+)
+
+type publishedSetpoint struct {
+	sent    dataOutPSU
+	sentAt  time.Time
+	pending *dataOutPSU // latest change held back by the rate limiter
+	fails   int         // failed sends in a row
+	retryAt time.Time   // no new attempt before this after a failed send
+}
+
+type psuPublisher struct {
+	mutex   sync.Mutex
+	cars    map[string]*publishedSetpoint // map of [carID]
+	current map[string]float64            // map of [carID], A, the current limit last computed
+}
+
+func (srv *Service) psuRefresh() time.Duration {
+	if srv.AllData.EStop.Active {
+		return estopRefresh
+	}
+	if srv.AllData.Settings.PSURefresh > 0 {
+		return time.Duration(srv.AllData.Settings.PSURefresh * float64(time.Second))
+	}
+	return defaultPSURefresh
+}
+
+// publishCarSetpoints computes the limits of every registered car and publishes those that changed
+func (srv *Service) publishCarSetpoints() {
+	limits, errs := srv.AllData.AllCarLimits()
+	for _, err := range errs {
+		logrus.WithError(err).Debug("Skipping PSU update")
+	}
+	for carID, l := range limits {
+		srv.publishLimits(carID, l) // failures are logged by sendSetpoint
+	}
+}
+
+// publishCarSetpoint computes and publishes the limits of one car
+func (srv *Service) publishCarSetpoint(carID string) error {
+	l, err := srv.AllData.CarLimits(carID)
+	if err != nil {
+		return err
+	}
+	return srv.publishLimits(carID, l)
+}
+
+// publishLimits records the car's current limit and publishes it as a setpoint, shaped by the speed governor
+func (srv *Service) publishLimits(carID string, l Limits) error {
+	srv.psuPub.mutex.Lock()
+	if srv.psuPub.current == nil {
+		srv.psuPub.current = make(map[string]float64)
+	}
+	srv.psuPub.current[carID] = l.I
+	srv.psuPub.mutex.Unlock()
+	return srv.publishSetpoint(carID, srv.setpoint(carID, l))
+}
+
+// limit returns the current limit last computed for the car
+func (p *psuPublisher) limit(carID string) (float64, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	i, ok := p.current[carID]
+	return i, ok
+}
+
+// setpoint is the setpoint the car gets for its limits, after the PSU commands, the speed governor and the emergency stop
+func (srv *Service) setpoint(carID string, l Limits) dataOutPSU {
+	sp := dataOutPSU{
+		U:      float32(l.U),
+		I:      float32(l.I),
+		Status: 1,
+	}
+	o := srv.commands.override(carID)
+	if o.U > 0 {
+		sp.U = float32(o.U)
+	}
+	if o.I > 0 {
+		sp.I = float32(o.I)
+	}
+	if o.U > 0 || o.I > 0 {
+		// The override was checked when it was queued, the caps may have tightened since
+		car := srv.AllData.CarMap[carID]
+		rules, class := srv.AllData.carRules(car)
+		u, i := capLimits(float64(sp.U), float64(sp.I), car.Params.SetVoltage, rules, class)
+		sp.U, sp.I = float32(u), float32(i)
+	}
+	if o.Off {
+		sp.Status = 0
+	}
+	sp = srv.governed(carID, sp)
+	if srv.AllData.EStop.Active {
+		sp.Status = 0
+	}
+	return sp
+}
+
+// published returns the setpoint last published for the car
+func (p *psuPublisher) published(carID string) (dataOutPSU, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	sp, ok := p.cars[carID]
+	if !ok || sp.sentAt.IsZero() {
+		return dataOutPSU{}, false
+	}
+	return sp.sent, true
+}
+
+// settled reports whether the car's setpoint was published with no change held back
+func (p *psuPublisher) settled(carID string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	sp, ok := p.cars[carID]
+	return ok && !sp.sentAt.IsZero() && sp.pending == nil
+}
+
+// publishSetpoint publishes the setpoint as a retained PSU_IN message when it differs from the last one
+// published for the car. Changes within psuMinInterval of the previous publish, or while backing off after
+// a failed send, are held back and sent by refreshSetpoints, only the latest one is kept.
+func (srv *Service) publishSetpoint(carID string, sp dataOutPSU) error {
+	srv.psuPub.mutex.Lock()
+	defer srv.psuPub.mutex.Unlock()
+	if srv.psuPub.cars == nil {
+		srv.psuPub.cars = make(map[string]*publishedSetpoint)
+	}
+	p, ok := srv.psuPub.cars[carID]
+	if ok && p.sent == sp {
+		p.pending = nil
+		return nil
+	}
+	if ok && (time.Since(p.sentAt) < psuMinInterval || time.Now().Before(p.retryAt)) {
+		p.pending = &sp
+		return nil
+	}
+	return srv.sendSetpoint(carID, sp)
+}
+
+// republishSetpoint sends the last setpoint again right away, for a car that reset and lost it
+func (srv *Service) republishSetpoint(carID string) error {
+	srv.psuPub.mutex.Lock()
+	defer srv.psuPub.mutex.Unlock()
+	p, ok := srv.psuPub.cars[carID]
+	if !ok {
+		return fmt.Errorf("no PSU setpoint published for car '%s' yet", carID)
+	}
+	sp := p.sent
+	if p.pending != nil {
+		sp = *p.pending
+	}
+	return srv.sendSetpoint(carID, sp)
+}
+
+// refreshSetpoints sends the changes held back by the rate limiter and re-publishes setpoints older than the refresh interval
+func (srv *Service) refreshSetpoints() {
+	srv.psuPub.mutex.Lock()
+	defer srv.psuPub.mutex.Unlock()
+	refresh := srv.psuRefresh()
+	for carID, p := range srv.psuPub.cars {
+		// Failures are logged by sendSetpoint
+		switch {
+		case time.Now().Before(p.retryAt):
+			continue
+		case p.pending != nil && time.Since(p.sentAt) >= psuMinInterval:
+			srv.sendSetpoint(carID, *p.pending)
+		case time.Since(p.sentAt) >= refresh:
+			srv.sendSetpoint(carID, p.sent)
+		}
+	}
+}
+
+// sendSetpoint publishes and records the setpoint, the caller holds the publisher lock. A failed setpoint is
+// retried with a growing back-off, the failure is logged when it starts and the recovery when it ends.
+func (srv *Service) sendSetpoint(carID string, sp dataOutPSU) error {
+	p, ok := srv.psuPub.cars[carID]
+	if !ok {
+		p = &publishedSetpoint{}
+		srv.psuPub.cars[carID] = p
+	}
+	err := errors.New("MQTT client not connected")
+	if srv.mqtt != nil {
+		err = srv.sendPSUData(carID, sp)
+	}
+	if err != nil {
+		p.pending = &sp
+		p.fails++
+		p.retryAt = time.Now().Add(psuRetry(p.fails))
+		if p.fails == 1 {
+			logrus.WithError(err).Errorf("PSU setpoint for car %s failed, retrying", carID)
+		}
+		return err
+	}
+	if p.fails > 0 {
+		logrus.Infof("PSU setpoint for car %s sent after %d failed attempts", carID, p.fails)
+	}
+	p.sent, p.sentAt, p.pending = sp, time.Now(), nil
+	p.fails, p.retryAt = 0, time.Time{}
+	return nil
+}
+
+// psuRetry is the back-off after the given number of failed sends in a row, doubling from psuMinInterval
+func psuRetry(fails int) time.Duration {
+	d := psuMinInterval
+	for i := 1; i < fails && d < psuMaxRetry; i++ {
+		d *= 2
+	}
+	return min(d, psuMaxRetry)
+}
+
+// clearSetpoint removes the retained setpoint of a car that is no longer registered
+func (srv *Service) clearSetpoint(carID string) error {
+	srv.psuPub.mutex.Lock()
+	delete(srv.psuPub.cars, carID)
+	delete(srv.psuPub.current, carID)
+	srv.psuPub.mutex.Unlock()
+	if srv.mqtt == nil {
+		return errors.New("MQTT client not connected")
+	}
+	return srv.sendAnyTopicRetained(fmt.Sprintf("PSU_IN/%s", carID), nil)
+}
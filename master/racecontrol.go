@@ -0,0 +1,208 @@
+package master
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	raceControlTopic = "RACE_CONTROL"
+	maxCountdown     = 600 // s
+)
+
+// StartCommand schedules a synchronized start of the listed cars Countdown seconds from now
+type StartCommand struct {
+	RaceName  string   `json:"raceName"`
+	Lap       int      `json:"Lap"`
+	Cars      []string `json:"Cars"`
+	Countdown int      `json:"Countdown"` // s
+}
+
+type ScheduledStart struct {
+	StartCommand
+	T0 time.Time `json:"T0"`
+}
+
+// Message sent to the cars on the race control topic
+type raceControlMessage struct {
+	Cmd      string   `json:"cmd"`
+	RaceName string   `json:"raceName"`
+	Lap      int      `json:"Lap"`
+	Cars     []string `json:"Cars"`
+	T0       int64    `json:"T0"` // unix ms
+}
+
+// Countdown data sent to the race topic in countdown, start and abort messages
+type countdownMessage struct {
+	RaceName  string    `json:"raceName"`
+	Lap       int       `json:"Lap"`
+	Cars      []string  `json:"Cars"`
+	Remaining int       `json:"Remaining"` // s
+	T0        time.Time `json:"T0"`
+}
+
+type raceControl struct {
+	mutex     sync.Mutex
+	scheduled *ScheduledStart
+	cancel    chan struct{}
+}
+
+func (c StartCommand) Validate() error {
+	if c.Countdown < 0 || c.Countdown > maxCountdown {
+		return fmt.Errorf("countdown must be between 0 and %d seconds", maxCountdown)
+	}
+	if len(c.Cars) == 0 {
+		return fmt.Errorf("no cars to start")
+	}
+	seen := map[string]bool{}
+	for _, car := range c.Cars {
+		if seen[car] {
+			return fmt.Errorf("car '%s' is listed more than once", car)
+		}
+		seen[car] = true
+	}
+	return nil
+}
+
+func (c StartCommand) instances() []StartInstance {
+	starts := make([]StartInstance, 0, len(c.Cars))
+	for _, car := range c.Cars {
+		starts = append(starts, StartInstance{RaceName: c.RaceName, Lap: c.Lap, CarID: car})
+	}
+	return starts
+}
+
+// ScheduleStart checks that every car can start and starts the countdown
+func (srv *Service) ScheduleStart(cmd StartCommand) (ScheduledStart, error) {
+	if err := cmd.Validate(); err != nil {
+		return ScheduledStart{}, err
+	}
+	if srv.AllData.EStop.Active {
+		return ScheduledStart{}, fmt.Errorf("the emergency stop is in force")
+	}
+	var errs []string
+	for _, s := range cmd.instances() {
+		if err := srv.AllData.CanStartRace(s); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return ScheduledStart{}, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+
+	srv.raceControl.mutex.Lock()
+	defer srv.raceControl.mutex.Unlock()
+	if s := srv.raceControl.scheduled; s != nil {
+		return ScheduledStart{}, fmt.Errorf("a start of race '%s' lap %d is already scheduled", s.RaceName, s.Lap)
+	}
+	start := ScheduledStart{
+		StartCommand: cmd,
+		T0:           time.Now().Add(time.Duration(cmd.Countdown) * time.Second),
+	}
+	cancel := make(chan struct{})
+	srv.raceControl.scheduled = &start
+	srv.raceControl.cancel = cancel
+
+	go srv.runCountdown(start, cancel)
+	return start, nil
+}
+
+// GetScheduledStart returns the start currently counting down
+func (srv *Service) GetScheduledStart() (ScheduledStart, bool) {
+	srv.raceControl.mutex.Lock()
+	defer srv.raceControl.mutex.Unlock()
+	if srv.raceControl.scheduled == nil {
+		return ScheduledStart{}, false
+	}
+	return *srv.raceControl.scheduled, true
+}
+
+// AbortStart cancels the scheduled start before T0
+func (srv *Service) AbortStart() (ScheduledStart, error) {
+	srv.raceControl.mutex.Lock()
+	defer srv.raceControl.mutex.Unlock()
+	if srv.raceControl.scheduled == nil {
+		return ScheduledStart{}, fmt.Errorf("no start is scheduled")
+	}
+	start := *srv.raceControl.scheduled
+	close(srv.raceControl.cancel)
+	srv.raceControl.scheduled = nil
+	srv.raceControl.cancel = nil
+	return start, nil
+}
+
+func (srv *Service) runCountdown(start ScheduledStart, cancel chan struct{}) {
+	for remaining := start.Countdown; remaining > 0; remaining-- {
+		broadcastCountdown("countdown", start, remaining)
+		select {
+		case <-cancel:
+			logrus.Infof("Start of race %s lap %d aborted", start.RaceName, start.Lap)
+			broadcastCountdown("abort", start, remaining)
+			return
+		case <-time.After(time.Until(start.T0.Add(-time.Duration(remaining-1) * time.Second))):
+		}
+	}
+
+	// An abort that raced with T0 wins only if it got the lock first
+	srv.raceControl.mutex.Lock()
+	select {
+	case <-cancel:
+		srv.raceControl.mutex.Unlock()
+		broadcastCountdown("abort", start, 0)
+		return
+	default:
+	}
+	srv.raceControl.scheduled = nil
+	srv.raceControl.cancel = nil
+	srv.raceControl.mutex.Unlock()
+
+	srv.startScheduled(start)
+}
+
+// startScheduled tells the cars to start and stamps every timer with T0
+func (srv *Service) startScheduled(start ScheduledStart) {
+	if err := srv.publishStart(start); err != nil {
+		logrus.WithError(err).Error("Race control")
+	}
+	for _, s := range start.instances() {
+		if err := srv.AllData.StartRaceAt(s, start.T0); err != nil {
+			logrus.WithError(err).Error("Race control")
+		}
+	}
+	srv.AllData.SaveToFile()
+	broadcastCountdown("start", start, 0)
+	logrus.Infof("Race %s lap %d started with %d cars", start.RaceName, start.Lap, len(start.Cars))
+}
+
+func (srv *Service) publishStart(start ScheduledStart) error {
+	if srv.mqtt == nil {
+		return errors.New("MQTT client not connected")
+	}
+	payload, err := json.Marshal(raceControlMessage{
+		Cmd:      "START",
+		RaceName: start.RaceName,
+		Lap:      start.Lap,
+		Cars:     start.Cars,
+		T0:       start.T0.UnixMilli(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "JSON")
+	}
+	return srv.sendAnyTopic(raceControlTopic, payload)
+}
+
+func broadcastCountdown(msgType string, start ScheduledStart, remaining int) {
+	Publish(msgType, countdownMessage{
+		RaceName:  start.RaceName,
+		Lap:       start.Lap,
+		Cars:      start.Cars,
+		Remaining: remaining,
+		T0:        start.T0,
+	}, raceTopic(start.RaceName))
+}
@@ -0,0 +1,239 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Ranking metrics a race can be scored by
+const (
+	MetricEfficiency = "efficiency" // lowest Wh/km/kg wins
+	MetricShellEff   = "shelleff"   // highest km/kWh wins
+	MetricTime       = "time"       // fastest elapsed time wins
+)
+
+// Finishing status of a car in a race
+const (
+	StatusFinished = "OK"
+	StatusDNF      = "DNF" // started but did not finish
+	StatusDNS      = "DNS" // did not start
+	StatusDSQ      = "DSQ" // disqualified
+)
+
+type ScoringRules struct {
+	Metric      string   `json:"Metric"`      // efficiency (default), shelleff or time
+	TieBreakers []string `json:"TieBreakers"` // metrics compared in order when the main metric is equal
+	PointsTable []int    `json:"PointsTable"` // points by finishing position, e.g. [10, 8, 6, 5]; empty disables automatic points
+	DNFPoints   int      `json:"DNFPoints"`
+	DNSPoints   int      `json:"DNSPoints"`
+
+	// Penalties were points deducted by car ID, they are now PenaltyPoints in the race data.
+	// Only read from old files, see migrateScoringPenalties.
+	Penalties map[string]int `json:"Penalties,omitempty"`
+}
+
+type RaceMetrics struct {
+	Efficiency float64 // Wh/km/kg
+	ShellEff   float64 // km/kWh
+	AvgPower   float64 // W
+	AvgSpeed   float64 // km/h
+}
+
+type RankedCar struct {
+	CarID    string
+	Status   string
+	Position int // shared by tied cars, 0 for cars that did not finish
+	Metrics  RaceMetrics
+}
+
+func validMetric(metric string) bool {
+	switch metric {
+	case MetricEfficiency, MetricShellEff, MetricTime:
+		return true
+	}
+	return false
+}
+
+func (rules ScoringRules) Validate() error {
+	if rules.Metric != "" && !validMetric(rules.Metric) {
+		return fmt.Errorf("unknown ranking metric '%s'", rules.Metric)
+	}
+	for _, m := range rules.TieBreakers {
+		if !validMetric(m) {
+			return fmt.Errorf("unknown tie-breaker metric '%s'", m)
+		}
+	}
+	if len(rules.Penalties) > 0 {
+		return fmt.Errorf("scoring penalties are no longer supported, issue points penalties on the race instead")
+	}
+	return nil
+}
+
+func (rules ScoringRules) metrics() []string {
+	metric := rules.Metric
+	if metric == "" {
+		metric = MetricEfficiency
+	}
+	return append([]string{metric}, rules.TieBreakers...)
+}
+
+func (d RaceData) Status() string {
+	if d.Disqualified() {
+		return StatusDSQ
+	}
+	if d.Finished {
+		return StatusFinished
+	}
+	if d.RaceTime > 0 || d.RaceMode {
+		return StatusDNF
+	}
+	return StatusDNS
+}
+
+// compareMetric returns a negative number when a ranks ahead of b by the given metric.
+// Metrics that could not be computed (zero length or time) rank behind any valid value.
+func compareMetric(metric string, a, b RankedCar, dataA, dataB RaceData) int {
+	var va, vb float64
+	lowerIsBetter := true
+	switch metric {
+	case MetricEfficiency:
+		va, vb = a.Metrics.Efficiency, b.Metrics.Efficiency
+	case MetricShellEff:
+		va, vb = a.Metrics.ShellEff, b.Metrics.ShellEff
+		lowerIsBetter = false
+	case MetricTime:
+		va, vb = dataA.PenalizedTime().Seconds(), dataB.PenalizedTime().Seconds()
+	}
+	validA, validB := va > 0, vb > 0
+	switch {
+	case validA && !validB:
+		return -1
+	case !validA && validB:
+		return 1
+	case va == vb:
+		return 0
+	case (va < vb) == lowerIsBetter:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// RankRace orders the cars of a race by the scoring rules. Finished cars come first with
+// positions assigned (equal cars share a position), followed by DNF, DNS and disqualified cars.
+func (a *AllData) RankRace(race Race) []RankedCar {
+	ranked := make([]RankedCar, 0, len(race.RaceData))
+	for carID, data := range race.RaceData {
+		ranked = append(ranked, RankedCar{
+			CarID:   carID,
+			Status:  data.Status(),
+			Metrics: raceMetrics(race, a.CarMap[carID], data),
+		})
+	}
+
+	statusOrder := map[string]int{StatusFinished: 0, StatusDNF: 1, StatusDNS: 2, StatusDSQ: 3}
+	metrics := race.Scoring.metrics()
+	compare := func(x, y RankedCar) int {
+		if statusOrder[x.Status] != statusOrder[y.Status] {
+			return statusOrder[x.Status] - statusOrder[y.Status]
+		}
+		if x.Status != StatusFinished {
+			return 0
+		}
+		for _, m := range metrics {
+			if c := compareMetric(m, x, y, race.RaceData[x.CarID], race.RaceData[y.CarID]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if c := compare(ranked[i], ranked[j]); c != 0 {
+			return c < 0
+		}
+		return ranked[i].CarID < ranked[j].CarID // deterministic order within ties
+	})
+
+	for i := range ranked {
+		if ranked[i].Status != StatusFinished {
+			continue
+		}
+		if i > 0 && compare(ranked[i-1], ranked[i]) == 0 {
+			ranked[i].Position = ranked[i-1].Position
+		} else {
+			ranked[i].Position = i + 1
+		}
+	}
+	return ranked
+}
+
+// UpdatePositions stores the ranked finishing positions in the race data, 0 for cars that did not finish
+func (a *AllData) UpdatePositions(key string) error {
+	race, ok := a.Races[key]
+	if !ok {
+		return fmt.Errorf("race '%s' not found", key)
+	}
+	for _, rc := range a.RankRace(race) {
+		data := race.RaceData[rc.CarID]
+		data.Position = rc.Position
+		race.RaceData[rc.CarID] = data
+	}
+	a.Races[key] = race
+	return nil
+}
+
+// ScoreRace computes points from the race's scoring rules and stores them in the race data.
+// Points entered manually are kept, the computed value is still recorded next to them.
+// Point penalties are deducted later, see RaceData.NetPoints.
+func (a *AllData) ScoreRace(key string) error {
+	race, ok := a.Races[key]
+	if !ok {
+		return fmt.Errorf("race '%s' not found", key)
+	}
+	if len(race.Scoring.PointsTable) == 0 {
+		return nil
+	}
+
+	for _, rc := range a.RankRace(race) {
+		var points int
+		switch rc.Status {
+		case StatusFinished:
+			if rc.Position <= len(race.Scoring.PointsTable) {
+				points = race.Scoring.PointsTable[rc.Position-1]
+			}
+		case StatusDNF:
+			points = race.Scoring.DNFPoints
+		case StatusDNS:
+			points = race.Scoring.DNSPoints
+		}
+
+		data := race.RaceData[rc.CarID]
+		data.AutoPoints = points
+		if !data.PointsOverride {
+			data.Points = points
+		}
+		race.RaceData[rc.CarID] = data
+	}
+	a.Races[key] = race
+	return nil
+}
+
+// ScoreRaces scores every lap of the race with the given name
+func (a *AllData) ScoreRaces(raceName string) error {
+	found := false
+	for key, race := range a.Races {
+		if race.RaceName == raceName {
+			found = true
+			if err := a.UpdatePositions(key); err != nil {
+				return err
+			}
+			if err := a.ScoreRace(key); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("race '%s' not found", raceName)
+	}
+	return a.UpdateLeaderboard()
+}
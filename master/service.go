@@ -31,6 +31,17 @@ type Service struct {
 	CarTable   CarIDMap
 	RaceTable  RaceNameMap
 	AllData    AllData
+
+	raceControl raceControl
+	psu         psuMonitor
+	psuPub      psuPublisher
+	governor    speedGovernor
+	commands    commandQueue
+	telemetry   telemetryMonitor
+	alerts      alertEngine
+	live        liveStream
+
+	alertRulesFile string
 }
 
 type Config struct {
@@ -40,6 +51,7 @@ type Config struct {
 	MqttPassword   string
 	InfluxdbUrl    string
 	InfluxdbApikey string
+	AlertRulesFile string // JSON list of alert rules used until rules are set through the API, optional
 }
 
 var lastMessageTime atomic.Int64
@@ -49,6 +61,7 @@ func withCORS(h httprouter.Handle) httprouter.Handle {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // or "http://localhost:5173"
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		if r.Method == "OPTIONS" {
@@ -72,6 +85,8 @@ func NewService(config Config) *Service {
 		username:   config.MqttUsername,
 		password:   config.MqttPassword,
 		Influxdb:   influxdb2.NewClient(config.InfluxdbUrl, config.InfluxdbApikey),
+
+		alertRulesFile: config.AlertRulesFile,
 	}
 
 	srv.AllData.LiveData = map[string]LiveDataInstance{}
@@ -135,6 +150,11 @@ func (srv *Service) Run() {
 		if err != nil {
 			logrus.WithError(errors.Wrap(err, "AllData")).Error("Error loading all data")
 		}
+		if srv.alertRulesFile != "" {
+			if err := srv.AllData.LoadAlertRules(srv.alertRulesFile); err != nil {
+				logrus.WithError(errors.Wrap(err, "AlertRules")).Error("Error loading alert rules")
+			}
+		}
 
 		mime.AddExtensionType(".js", "application/javascript")
 		mime.AddExtensionType(".css", "text/css")
@@ -146,13 +166,51 @@ func (srv *Service) Run() {
 
 		router.GET("/api/cars", withCORS(srv.getCars))
 		router.POST("/api/cars", withCORS(srv.postCars))
+		router.POST("/api/cars/:id/pit/in", withCORS(srv.postPitIn))
+		router.POST("/api/cars/:id/pit/out", withCORS(srv.postPitOut))
+		router.GET("/api/cars/:id/psu", withCORS(srv.getPSUState))
+		router.GET("/api/cars/:id/limits", withCORS(srv.getCarLimits))
+		router.GET("/api/cars/:id/telemetry", withCORS(srv.getCarTelemetry))
+		router.GET("/api/telemetry", withCORS(srv.getTelemetry))
+		router.GET("/api/telemetry/events", withCORS(srv.getTelemetryEvents))
+		router.GET("/api/alerts", withCORS(srv.getAlerts))
+		router.GET("/api/alerts/history", withCORS(srv.getAlertHistory))
+		router.POST("/api/alerts/ack", withCORS(srv.postAckAlert))
+		router.GET("/api/alerts/rules", withCORS(srv.getAlertRules))
+		router.POST("/api/alerts/rules", withCORS(srv.postAlertRules))
+		router.GET("/api/cars/:id/commands", withCORS(srv.getCommands))
+		router.POST("/api/cars/:id/commands", withCORS(srv.postCommand))
+		router.GET("/api/cars/:id/commands/:cmd", withCORS(srv.getCommand))
+		router.GET("/api/psu", withCORS(srv.getPSUStates))
+		router.GET("/api/classes", withCORS(srv.getClasses))
+		router.POST("/api/classes", withCORS(srv.postClasses))
+		router.GET("/api/tracks", withCORS(srv.getTracks))
+		router.POST("/api/tracks", withCORS(srv.postTracks))
+		router.GET("/api/tracks/:id", withCORS(srv.getTrack))
 		router.GET("/api/races", withCORS(srv.getRaces))
 		router.POST("/api/races", withCORS(srv.postRaces))
+		router.POST("/api/races/:name/score", withCORS(srv.postScoreRace))
+		router.GET("/api/races/:name/laps/:lap/penalties", withCORS(srv.getPenalties))
+		router.POST("/api/races/:name/laps/:lap/penalties", withCORS(srv.postPenalty))
+		router.DELETE("/api/races/:name/laps/:lap/penalties/:id", withCORS(srv.deletePenalty))
 		router.GET("/api/results/:racename", withCORS(srv.getResults))
+		router.GET("/api/results/:racename/sheet", withCORS(srv.getResultsSheet))
 		router.GET("/api/leaderboard/:agegroup", withCORS(srv.getLeaderboard))
 		router.DELETE("/api/leaderboard/:agegroup", withCORS(srv.deleteLeaderboard))
+		router.GET("/api/leaderboard/:agegroup/snapshots", withCORS(srv.getLeaderboardSnapshots))
+		router.POST("/api/leaderboard/:agegroup/snapshots", withCORS(srv.postLeaderboardSnapshot))
+		router.GET("/api/leaderboard/:agegroup/snapshots/:id", withCORS(srv.getLeaderboardSnapshot))
+		router.GET("/api/leaderboard/:agegroup/diff", withCORS(srv.getLeaderboardDiff))
 		router.POST("/api/race/start", withCORS(srv.postStartRace))
 		router.POST("/api/race/finish", withCORS(srv.postRaceFinish))
+		router.GET("/api/race-control/start", withCORS(srv.getScheduledStart))
+		router.POST("/api/race-control/start", withCORS(srv.postScheduleStart))
+		router.DELETE("/api/race-control/start", withCORS(srv.deleteScheduledStart))
+		router.GET("/api/race-control/estop", withCORS(srv.getEStop))
+		router.POST("/api/race-control/estop", withCORS(srv.postEStop))
+		router.POST("/api/race-control/release", withCORS(srv.postReleaseEStop))
+		router.GET("/api/race-control/governor", withCORS(srv.getGovernorStates))
+		router.GET("/api/race-control/governor/events", withCORS(srv.getGovernorEvents))
 		router.POST("/api/car/finish", withCORS(srv.postCarFinish))
 		router.POST("/api/points", withCORS(srv.postPoints))
 		router.DELETE("/api/points", withCORS(srv.deletePoints))
@@ -203,25 +261,18 @@ func (srv *Service) Run() {
 			}
 		}()
 
+		// Setpoints are published when they change, recomputing them here follows ramp-ups
 		for {
-			for carID, car := range srv.AllData.CarMap {
-				// Calculate max current
-				if car.Params.SetVoltage <= 0 {
-					logrus.Warnf("SetVoltage for car %s is zero or negative, skipping PSU update", carID)
-					continue
-				}
-				maxCurrent := car.Params.Mass * srv.AllData.Settings.RaceCoeficient / car.Params.SetVoltage
-				car.Params.MaxCurrent = maxCurrent
-
-				payload := dataOutPSU{
-					U:      float32(car.Params.SetVoltage),
-					I:      float32(car.Params.MaxCurrent),
-					Status: 1,
-				}
-
-				srv.sendPSUData(carID, payload)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(psuTick):
+				srv.publishCarSetpoints()
+				srv.refreshSetpoints()
+				srv.processCommands()
+				srv.checkTelemetry(time.Now())
+				srv.checkAlertAges(time.Now())
 			}
-			time.Sleep(1 * time.Second) // send PSU data every 5 seconds
 		}
 	}()
 
@@ -231,7 +282,7 @@ func (srv *Service) Run() {
 
 		for {
 			srv.SendLiveData()
-			time.Sleep(1 * time.Second) // send live data every second
+			time.Sleep(srv.liveInterval()) // Settings.LiveRate, once a second by default
 		}
 	}()
 
@@ -0,0 +1,196 @@
+package master
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ResultsSheet struct {
+	RaceName    string
+	GeneratedAt time.Time
+	UUID        string
+	Settings    Settings
+	Laps        []ResultsSheetLap
+}
+
+type ResultsSheetLap struct {
+	Lap     int
+	Length  float64
+	Results []Result
+}
+
+// Labels used on the printed results sheet in addition to the export column headers
+var sheetLabels = map[string]map[string]string{
+	"lv": {
+		"Title":     "Oficiālie rezultāti",
+		"Length":    "Garums (m)",
+		"PowerCoef": "Jaudas koeficients",
+		"MaxSpd":    "Maksimālais ātrums (km/h)",
+		"Generated": "Izveidots",
+		"DataID":    "Datu ID",
+		"Signature": "Galvenā tiesneša paraksts",
+		"Date":      "Datums",
+	},
+	"en": {
+		"Title":     "Official results",
+		"Length":    "Length (m)",
+		"PowerCoef": "Power coefficient",
+		"MaxSpd":    "Maximum speed (km/h)",
+		"Generated": "Generated",
+		"DataID":    "Data ID",
+		"Signature": "Chief official's signature",
+		"Date":      "Date",
+	},
+}
+
+func sheetLabel(lang, key string) string {
+	if l, ok := sheetLabels[lang][key]; ok {
+		return l
+	}
+	return exportHeader(lang, key)
+}
+
+// GetResultsSheet collects the results of every lap of the race together with the settings they were computed with
+func (a *AllData) GetResultsSheet(raceName string) (ResultsSheet, error) {
+	sheet := ResultsSheet{
+		RaceName:    raceName,
+		GeneratedAt: time.Now(),
+		UUID:        a.UUID.String(),
+		Settings:    a.Settings,
+	}
+	for key, race := range a.Races {
+		if race.RaceName != raceName {
+			continue
+		}
+		results, err := a.GetResults(key)
+		if err != nil {
+			return sheet, err
+		}
+		sheet.Laps = append(sheet.Laps, ResultsSheetLap{
+			Lap:     race.Lap,
+			Length:  race.Length,
+			Results: results,
+		})
+	}
+	if len(sheet.Laps) == 0 {
+		return sheet, fmt.Errorf("race '%s' not found", raceName)
+	}
+	sort.Slice(sheet.Laps, func(i, j int) bool {
+		return sheet.Laps[i].Lap < sheet.Laps[j].Lap
+	})
+	return sheet, nil
+}
+
+var sheetTemplate = template.Must(template.New("sheet").Funcs(template.FuncMap{
+	"label":   sheetLabel,
+	"number":  func(f float64) string { return fmt.Sprintf("%.2f", f) },
+	"seconds": func(d time.Duration) string { return fmt.Sprintf("%.1f", d.Seconds()) },
+	"stamp":   func(t time.Time) string { return t.Format("02.01.2006 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+	<head>
+		<meta charset="UTF-8" />
+		<title>{{.Sheet.RaceName}} - {{label .Lang "Title"}}</title>
+		<style>
+			@page { size: A4; margin: 15mm; }
+			body { font-family: sans-serif; font-size: 11pt; color: #000; }
+			h1 { font-size: 18pt; margin-bottom: 4pt; }
+			h2 { font-size: 13pt; margin-top: 14pt; }
+			table { width: 100%; border-collapse: collapse; page-break-inside: avoid; }
+			th, td { border: 1px solid #000; padding: 3pt 5pt; }
+			td.num { text-align: right; }
+			.meta { font-size: 9pt; }
+			.signature { margin-top: 36pt; }
+			.signature span { display: inline-block; width: 60mm; border-bottom: 1px solid #000; margin-right: 12mm; }
+			@media print { .noprint { display: none; } }
+		</style>
+	</head>
+	<body>
+		<h1>{{label .Lang "Title"}}: {{.Sheet.RaceName}}</h1>
+		<div class="meta">
+			{{label .Lang "PowerCoef"}}: {{number .Sheet.Settings.RaceCoeficient}} &middot;
+			{{label .Lang "MaxSpd"}}: {{number .Sheet.Settings.MaxSpeed}}<br />
+			{{label .Lang "Generated"}}: {{stamp .Sheet.GeneratedAt}} &middot;
+			{{label .Lang "DataID"}}: {{.Sheet.UUID}}
+		</div>
+		{{range .Sheet.Laps}}
+		<h2>{{label $.Lang "Lap"}} {{.Lap}} &middot; {{label $.Lang "Length"}}: {{number .Length}}</h2>
+		<table>
+			<tr>
+				<th>{{label $.Lang "Position"}}</th>
+				<th>{{label $.Lang "ID"}}</th>
+				<th>{{label $.Lang "Username"}}</th>
+				<th>{{label $.Lang "Used energy"}}</th>
+				<th>{{label $.Lang "Elapsed time"}}</th>
+				<th>{{label $.Lang "Efficiency"}}</th>
+				<th>{{label $.Lang "Shelleficiency"}}</th>
+				<th>{{label $.Lang "Points"}}</th>
+			</tr>
+			{{range .Results}}
+			<tr>
+				<td>{{if .Position}}{{.Position}}{{else}}{{.Status}}{{end}}</td>
+				<td>{{.CarID}}</td>
+				<td>{{.Username}}</td>
+				<td class="num">{{number .UsedEnergy}}</td>
+				<td class="num">{{seconds .ElapsedTime}}</td>
+				<td class="num">{{number .Efficiency}}</td>
+				<td class="num">{{number .ShellEff}}</td>
+				<td class="num">{{.Points}}</td>
+			</tr>
+			{{end}}
+		</table>
+		{{end}}
+		<div class="signature">
+			{{label .Lang "Signature"}}: <span></span>
+			{{label .Lang "Date"}}: <span></span>
+		</div>
+		<p class="noprint"><button onclick="window.print()">Print</button></p>
+	</body>
+</html>`))
+
+func writeResultsSheetHTML(w io.Writer, sheet ResultsSheet, lang string) error {
+	return sheetTemplate.Execute(w, struct {
+		Sheet ResultsSheet
+		Lang  string
+	}{sheet, lang})
+}
+
+// writeResultsSheetPDF renders the same content as the HTML sheet as fixed-width text lines
+func writeResultsSheetPDF(w io.Writer, sheet ResultsSheet, lang string) error {
+	var lines []string
+	lines = append(lines,
+		fmt.Sprintf("%s: %s", sheetLabel(lang, "Title"), sheet.RaceName),
+		"",
+		fmt.Sprintf("%s: %.2f", sheetLabel(lang, "PowerCoef"), sheet.Settings.RaceCoeficient),
+		fmt.Sprintf("%s: %.2f", sheetLabel(lang, "MaxSpd"), sheet.Settings.MaxSpeed),
+		fmt.Sprintf("%s: %s", sheetLabel(lang, "Generated"), sheet.GeneratedAt.Format("02.01.2006 15:04:05")),
+		fmt.Sprintf("%s: %s", sheetLabel(lang, "DataID"), sheet.UUID),
+	)
+	row := "%-5s %-6s %-18s %10s %10s %10s %10s %6s"
+	for _, lap := range sheet.Laps {
+		lines = append(lines,
+			"",
+			fmt.Sprintf("%s %d, %s: %.2f", sheetLabel(lang, "Lap"), lap.Lap, sheetLabel(lang, "Length"), lap.Length),
+			fmt.Sprintf(row, "#", "ID", sheetLabel(lang, "Username"), "Wh", "s", "Wh/km/kg", "km/kWh", sheetLabel(lang, "Points")),
+			strings.Repeat("-", 82),
+		)
+		for _, res := range lap.Results {
+			position := res.Status
+			if res.Position > 0 {
+				position = strconv.Itoa(res.Position)
+			}
+			lines = append(lines, fmt.Sprintf("%-5s %-6s %-18.18s %10.2f %10.1f %10.2f %10.2f %6d",
+				position, res.CarID, res.Username, res.UsedEnergy, res.ElapsedTime.Seconds(), res.Efficiency, res.ShellEff, res.Points))
+		}
+	}
+	lines = append(lines,
+		"", "", "",
+		fmt.Sprintf("%s: ______________________    %s: ____________", sheetLabel(lang, "Signature"), sheetLabel(lang, "Date")),
+	)
+	return writePDF(w, lines)
+}
@@ -0,0 +1,182 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const leaderboardMaxSnapshots = 200 // per age group, the oldest are dropped
+
+type LeaderboardSnapshot struct {
+	ID        int                `json:"ID"`
+	Name      string             `json:"Name"`
+	AgeGroup  string             `json:"AgeGroup"`
+	CreatedAt time.Time          `json:"CreatedAt"`
+	Entries   []LeaderboardEntry `json:"Entries,omitempty"`
+}
+
+type LeaderboardDiffEntry struct {
+	CarID        string `json:"ID"`
+	Username     string `json:"username"`
+	FromPosition int    `json:"From position"` // 0 when the car is not in the snapshot
+	ToPosition   int    `json:"To position"`
+	PosChange    int    `json:"Position change"` // positive when the car moved up
+	FromTotal    int    `json:"From total"`
+	ToTotal      int    `json:"To total"`
+	PointsChange int    `json:"Points change"`
+}
+
+type LeaderboardDiff struct {
+	AgeGroup string                 `json:"AgeGroup"`
+	From     LeaderboardSnapshot    `json:"From"`
+	To       LeaderboardSnapshot    `json:"To"`
+	Entries  []LeaderboardDiffEntry `json:"Entries"`
+}
+
+// TakeLeaderboardSnapshot stores a copy of the current leaderboard of the age group under the given name
+func (a *AllData) TakeLeaderboardSnapshot(ageGroup, name string) (LeaderboardSnapshot, error) {
+	if id, ok := a.ClassID(ageGroup); ok {
+		ageGroup = id
+	}
+	entries, ok := a.Leaderboards[ageGroup]
+	if !ok {
+		return LeaderboardSnapshot{}, fmt.Errorf("no leaderboard found for age group %s", ageGroup)
+	}
+	if a.LeaderboardSnapshots == nil {
+		a.LeaderboardSnapshots = make(map[string][]LeaderboardSnapshot)
+	}
+	snapshots := a.LeaderboardSnapshots[ageGroup]
+
+	id := 1
+	if len(snapshots) > 0 {
+		id = snapshots[len(snapshots)-1].ID + 1
+	}
+	if name == "" {
+		name = fmt.Sprintf("Snapshot %d", id)
+	}
+	snapshot := LeaderboardSnapshot{
+		ID:        id,
+		Name:      name,
+		AgeGroup:  ageGroup,
+		CreatedAt: time.Now(),
+		Entries:   append([]LeaderboardEntry(nil), entries...),
+	}
+	snapshots = append(snapshots, snapshot)
+	if len(snapshots) > leaderboardMaxSnapshots {
+		snapshots = snapshots[len(snapshots)-leaderboardMaxSnapshots:]
+	}
+	a.LeaderboardSnapshots[ageGroup] = snapshots
+	return snapshot, nil
+}
+
+// TakeLeaderboardSnapshots snapshots the leaderboards of all age groups under the same name
+func (a *AllData) TakeLeaderboardSnapshots(name string) {
+	groups := make([]string, 0, len(a.Leaderboards))
+	for group := range a.Leaderboards {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		a.TakeLeaderboardSnapshot(group, name)
+	}
+}
+
+// GetLeaderboardSnapshots lists the snapshots of the age group without their entries
+func (a *AllData) GetLeaderboardSnapshots(ageGroup string) []LeaderboardSnapshot {
+	if id, ok := a.ClassID(ageGroup); ok {
+		ageGroup = id
+	}
+	snapshots := make([]LeaderboardSnapshot, 0, len(a.LeaderboardSnapshots[ageGroup]))
+	for _, s := range a.LeaderboardSnapshots[ageGroup] {
+		s.Entries = nil
+		snapshots = append(snapshots, s)
+	}
+	return snapshots
+}
+
+func (a *AllData) GetLeaderboardSnapshot(ageGroup string, id int) (LeaderboardSnapshot, error) {
+	if class, ok := a.ClassID(ageGroup); ok {
+		ageGroup = class
+	}
+	for _, s := range a.LeaderboardSnapshots[ageGroup] {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+	return LeaderboardSnapshot{}, fmt.Errorf("no snapshot %d found for age group %s", id, ageGroup)
+}
+
+// SetLeaderboardBaselines makes the current leaderboards the baselines relative positions are computed against,
+// until the next baselines are set. A group that has no leaderboard yet gets an empty baseline.
+func (a *AllData) SetLeaderboardBaselines() {
+	a.Baselines = make(map[string][]LeaderboardEntry, len(a.Leaderboards))
+	for group, entries := range a.Leaderboards {
+		a.Baselines[group] = append([]LeaderboardEntry{}, entries...)
+	}
+}
+
+// leaderboardBaseline is the leaderboard relative positions are computed against, there is none before the
+// first race finished or the first reset
+func (a *AllData) leaderboardBaseline(ageGroup string) ([]LeaderboardEntry, bool) {
+	if a.Baselines == nil {
+		return nil, false
+	}
+	return a.Baselines[ageGroup], true
+}
+
+// DiffLeaderboardSnapshots compares two snapshots of the age group. A zero "to" ID compares against the current leaderboard.
+func (a *AllData) DiffLeaderboardSnapshots(ageGroup string, fromID, toID int) (LeaderboardDiff, error) {
+	if id, ok := a.ClassID(ageGroup); ok {
+		ageGroup = id
+	}
+	diff := LeaderboardDiff{AgeGroup: ageGroup}
+	from, err := a.GetLeaderboardSnapshot(ageGroup, fromID)
+	if err != nil {
+		return diff, err
+	}
+	to := LeaderboardSnapshot{Name: "current", AgeGroup: ageGroup, CreatedAt: time.Now(), Entries: a.Leaderboards[ageGroup]}
+	if toID != 0 {
+		if to, err = a.GetLeaderboardSnapshot(ageGroup, toID); err != nil {
+			return diff, err
+		}
+	}
+
+	before := map[string]LeaderboardEntry{}
+	for _, e := range from.Entries {
+		before[e.CarID] = e
+	}
+	seen := map[string]bool{}
+	for _, e := range to.Entries {
+		seen[e.CarID] = true
+		d := LeaderboardDiffEntry{
+			CarID:      e.CarID,
+			Username:   e.Username,
+			ToPosition: e.Position,
+			ToTotal:    e.Total,
+		}
+		if prev, ok := before[e.CarID]; ok {
+			d.FromPosition = prev.Position
+			d.FromTotal = prev.Total
+			d.PosChange = prev.Position - e.Position
+		}
+		d.PointsChange = d.ToTotal - d.FromTotal
+		diff.Entries = append(diff.Entries, d)
+	}
+	// Cars that dropped off the leaderboard are listed last
+	for _, e := range from.Entries {
+		if !seen[e.CarID] {
+			diff.Entries = append(diff.Entries, LeaderboardDiffEntry{
+				CarID:        e.CarID,
+				Username:     e.Username,
+				FromPosition: e.Position,
+				FromTotal:    e.Total,
+				PointsChange: -e.Total,
+			})
+		}
+	}
+
+	from.Entries, to.Entries = nil, nil
+	diff.From, diff.To = from, to
+	return diff, nil
+}
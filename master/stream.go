@@ -51,16 +51,20 @@ type payloadOutPSU struct {
 	} `json:"PSU"`
 }
 type payloadPSU struct {
-	Uop int `json:"Uop"`
-	Iop int `json:"Iop"`
-	Pop int `json:"Pop"`
-	Uip int `json:"Uip"`
-	Wh  int `json:"Wh"`
+	Uop  int  `json:"Uop"`
+	Iop  int  `json:"Iop"`
+	Pop  int  `json:"Pop"`
+	Uip  int  `json:"Uip"`
+	Wh   int  `json:"Wh"`
+	Temp *int `json:"Temp,omitempty"` // optional, °C × 100
 }
 type payloadGPS struct {
-	Lat float32 `json:"Lat"`
-	Lon float32 `json:"Lon"`
-	Spd float32 `json:"Spd"`
+	Lat  float64 `json:"Lat"` // in the car's GPS format, see gps.go
+	Lon  float64 `json:"Lon"`
+	Spd  float64 `json:"Spd"`
+	Sats int     `json:"Sats"` // optional
+	HDOP float64 `json:"HDOP"` // optional
+	NMEA string  `json:"NMEA"` // raw sentences instead of Lat/Lon
 }
 type payloadAccel struct {
 	X float32 `json:"X"`
@@ -85,11 +89,12 @@ type dataPSU struct { // psu dati ir jāreizina/jādala ar 100, piem. "U":4976 (
 	Pop  float32
 	Uip  float32
 	Wh   float32
+	Temp *float32 // °C, nil when the car does not report it
 	Time time.Time
 }
 type dataGPS struct {
-	Lat  float32
-	Lon  float32
+	Lat  float64
+	Lon  float64
 	Spd  float32
 	Time time.Time
 }
@@ -208,7 +213,7 @@ func (srv *Service) handleTopicGPS(ctx context.Context, client mqtt.Client, msg
 	data := dataGPS{
 		Lat:  payload.GPS.Lat,
 		Lon:  payload.GPS.Lon,
-		Spd:  payload.GPS.Spd,
+		Spd:  float32(payload.GPS.Spd),
 		Time: time.Now(),
 	}
 
@@ -380,29 +385,41 @@ func (srv *Service) handleTopicSUS(ctx context.Context, client mqtt.Client, msg
 
 // Send the PSU data
 func (srv *Service) sendPSUData(carID string, data dataOutPSU) error {
-	payload := payloadOutPSU{}
-	payload.PSU.U = int(math.Round(float64(data.U) * 100.0))
-	payload.PSU.I = int(math.Round(float64(data.I) * 100.0))
-	payload.PSU.St = data.Status
-	if payload.PSU.U <= 0 || payload.PSU.I <= 0 {
-		return errors.New("Invalid PSU data: U and I must be greater than 0")
-	}
-	if payload.PSU.I > 2000 {
-		payload.PSU.I = 2000 // Limit max current to 20A
+	payload, err := psuPayload(data)
+	if err != nil {
+		return err
 	}
 	bytes, err := json.Marshal(payload)
 	if err != nil {
 		return errors.Wrap(err, "JSON")
 	}
 
-	token := srv.mqtt.Publish(fmt.Sprintf("PSU_IN/%s", carID), 1, false, bytes)
+	// Retained, so a car that reconnects gets its setpoint without waiting for the next refresh
+	token := srv.mqtt.Publish(fmt.Sprintf("PSU_IN/%s", carID), 1, true, bytes)
 	token.Wait()
 	if err := token.Error(); err != nil {
 		return errors.Wrap(err, "MQTT")
 	}
+	srv.psu.commanded(carID, float64(payload.PSU.U)/100, float64(payload.PSU.I)/100, payload.PSU.St != 0, time.Now())
 	return nil
 }
 
+// psuPayload encodes a setpoint in centivolts and centiamps. A setpoint that switches the output off
+// needs no limits, so an emergency stop gets through even when the limits come out as 0.
+func psuPayload(data dataOutPSU) (payloadOutPSU, error) {
+	payload := payloadOutPSU{}
+	payload.PSU.U = int(math.Round(float64(data.U) * 100.0))
+	payload.PSU.I = int(math.Round(float64(data.I) * 100.0))
+	payload.PSU.St = data.Status
+	if payload.PSU.St == 0 {
+		payload.PSU.U = max(payload.PSU.U, 0)
+		payload.PSU.I = max(payload.PSU.I, 0)
+	} else if payload.PSU.U <= 0 || payload.PSU.I <= 0 {
+		return payload, errors.New("Invalid PSU data: U and I must be greater than 0")
+	}
+	return payload, nil
+}
+
 func (srv *Service) sendAnyTopic(topic string, payload []byte) error {
 	token := srv.mqtt.Publish(topic, 1, false, payload)
 	token.Wait()
@@ -411,3 +428,13 @@ func (srv *Service) sendAnyTopic(topic string, payload []byte) error {
 	}
 	return nil
 }
+
+// sendAnyTopicRetained publishes a retained message, an empty payload clears the retained one
+func (srv *Service) sendAnyTopicRetained(topic string, payload []byte) error {
+	token := srv.mqtt.Publish(topic, 1, true, payload)
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return errors.Wrap(err, "MQTT")
+	}
+	return nil
+}
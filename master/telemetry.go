@@ -0,0 +1,242 @@
+package master
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Sensors reporting over MQTT
+const (
+	SensorPSU   = "PSU"
+	SensorGPS   = "GPS"
+	SensorAccel = "Accel"
+	SensorSUS   = "SUS"
+)
+
+// Car telemetry status
+const (
+	TelemetryOnline   = "online"   // every sensor the car has reported from is fresh
+	TelemetryDegraded = "degraded" // some sensors went stale
+	TelemetryOffline  = "offline"  // nothing fresh from the car
+)
+
+const telemetryMaxEvents = 1000
+
+var defaultStale = map[string]float64{ // s
+	SensorPSU:   5,
+	SensorGPS:   5,
+	SensorAccel: 5,
+	SensorSUS:   60, // SUS only reports speed and resets
+}
+
+// TelemetryRules set how long a sensor may stay silent before it is stale, 0 for the default
+type TelemetryRules struct {
+	PSU   float64 `json:"PSU"`   // s
+	GPS   float64 `json:"GPS"`   // s
+	Accel float64 `json:"Accel"` // s
+	SUS   float64 `json:"SUS"`   // s
+}
+
+// CarTelemetry is the staleness of a car's sensors. Only sensors the car has reported from count.
+type CarTelemetry struct {
+	CarID    string               `json:"ID"`
+	Status   string               `json:"Status"`
+	Stale    []string             `json:"Stale"`
+	LastSeen map[string]time.Time `json:"LastSeen"` // map of [sensor]
+	Since    time.Time            `json:"Since"`    // when the status last changed
+}
+
+// TelemetryEvent records a change of a car's telemetry status
+type TelemetryEvent struct {
+	Seq      int       `json:"Seq"`
+	CarID    string    `json:"ID"`
+	Status   string    `json:"Status"`
+	Previous string    `json:"Previous"` // empty on the first evaluation
+	Stale    []string  `json:"Stale"`
+	Racing   bool      `json:"Racing"` // the car went dark or came back mid-race
+	Time     time.Time `json:"Time"`
+}
+
+type telemetryMonitor struct {
+	mutex  sync.Mutex
+	cars   map[string]*CarTelemetry // map of [carID]
+	events []TelemetryEvent
+	seq    int
+}
+
+func (r TelemetryRules) Validate() error {
+	if r.PSU < 0 || r.GPS < 0 || r.Accel < 0 || r.SUS < 0 {
+		return fmt.Errorf("stale thresholds must not be negative")
+	}
+	return nil
+}
+
+func (r TelemetryRules) stale(sensor string) time.Duration {
+	s := map[string]float64{SensorPSU: r.PSU, SensorGPS: r.GPS, SensorAccel: r.Accel, SensorSUS: r.SUS}[sensor]
+	if s <= 0 {
+		s = defaultStale[sensor]
+	}
+	return time.Duration(s * float64(time.Second))
+}
+
+// seen records a message from one of the car's sensors
+func (m *telemetryMonitor) seen(carID, sensor string, at time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	t := m.car(carID)
+	t.LastSeen[sensor] = at
+}
+
+// car returns the car's telemetry, the caller holds the lock
+func (m *telemetryMonitor) car(carID string) *CarTelemetry {
+	if m.cars == nil {
+		m.cars = make(map[string]*CarTelemetry)
+	}
+	t, ok := m.cars[carID]
+	if !ok {
+		t = &CarTelemetry{CarID: carID, LastSeen: make(map[string]time.Time)}
+		m.cars[carID] = t
+	}
+	return t
+}
+
+// evaluate works out the car's status at now. It returns the event when the status changed.
+func (m *telemetryMonitor) evaluate(carID string, rules TelemetryRules, racing bool, now time.Time) (TelemetryEvent, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	t := m.car(carID)
+
+	stale := []string{}
+	for _, sensor := range []string{SensorPSU, SensorGPS, SensorAccel, SensorSUS} {
+		if at, ok := t.LastSeen[sensor]; ok && now.Sub(at) > rules.stale(sensor) {
+			stale = append(stale, sensor)
+		}
+	}
+	status := TelemetryOnline
+	switch {
+	case len(stale) == len(t.LastSeen):
+		status = TelemetryOffline
+	case len(stale) > 0:
+		status = TelemetryDegraded
+	}
+	t.Stale = stale
+
+	previous := t.Status
+	if status == previous {
+		return TelemetryEvent{}, false
+	}
+	t.Status, t.Since = status, now
+	// A racing car is reported from the first evaluation, e.g. one still dark after a restart mid-race
+	if previous == "" && !racing {
+		return TelemetryEvent{}, false
+	}
+	m.seq++
+	e := TelemetryEvent{Seq: m.seq, CarID: carID, Status: status, Previous: previous, Stale: stale, Racing: racing, Time: now}
+	m.events = append(m.events, e)
+	if len(m.events) > telemetryMaxEvents {
+		m.events = m.events[len(m.events)-telemetryMaxEvents:]
+	}
+	return e, true
+}
+
+func (m *telemetryMonitor) get(carID string) (CarTelemetry, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	t, ok := m.cars[carID]
+	if !ok {
+		return CarTelemetry{}, false
+	}
+	return t.copy(), true
+}
+
+func (m *telemetryMonitor) all() []CarTelemetry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	cars := make([]CarTelemetry, 0, len(m.cars))
+	for _, t := range m.cars {
+		cars = append(cars, t.copy())
+	}
+	sort.Slice(cars, func(i, j int) bool { return cars[i].CarID < cars[j].CarID })
+	return cars
+}
+
+// eventsOf returns the logged events, of one car when carID is not empty, oldest first
+func (m *telemetryMonitor) eventsOf(carID string) []TelemetryEvent {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	events := []TelemetryEvent{}
+	for _, e := range m.events {
+		if carID == "" || e.CarID == carID {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func (m *telemetryMonitor) remove(carID string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.cars, carID)
+}
+
+func (t *CarTelemetry) copy() CarTelemetry {
+	c := *t
+	c.Stale = append([]string{}, t.Stale...)
+	c.LastSeen = make(map[string]time.Time, len(t.LastSeen))
+	for sensor, at := range t.LastSeen {
+		c.LastSeen[sensor] = at
+	}
+	return c
+}
+
+func (srv *Service) GetCarTelemetry(carID string) (CarTelemetry, error) {
+	if _, ok := srv.AllData.CarMap[carID]; !ok {
+		return CarTelemetry{}, fmt.Errorf("car with ID '%s' not found", carID)
+	}
+	t, ok := srv.telemetry.get(carID)
+	if !ok {
+		return CarTelemetry{CarID: carID, Status: TelemetryOffline, Stale: []string{}, LastSeen: map[string]time.Time{}}, nil
+	}
+	return t, nil
+}
+
+func (srv *Service) GetTelemetry() []CarTelemetry {
+	return srv.telemetry.all()
+}
+
+func (srv *Service) GetTelemetryEvents(carID string) []TelemetryEvent {
+	return srv.telemetry.eventsOf(carID)
+}
+
+// checkTelemetry evaluates every registered car's telemetry status and reports the changes
+func (srv *Service) checkTelemetry(now time.Time) {
+	for carID := range srv.AllData.CarMap {
+		e, ok := srv.telemetry.evaluate(carID, srv.AllData.Settings.Telemetry, srv.AllData.racing(carID), now)
+		t, _ := srv.telemetry.get(carID)
+		srv.AllData.UpdateLiveDataCarTelemetry(carID, t.Status, t.Stale)
+		if !ok {
+			continue
+		}
+		if e.Racing && e.Status != TelemetryOnline {
+			logrus.Warnf("Car %s went %s mid-race, stale: %v", carID, e.Status, e.Stale)
+		} else {
+			logrus.Infof("Car %s telemetry %s, stale: %v", carID, e.Status, e.Stale)
+		}
+
+		Publish("telemetry", e, TopicAlerts, carTopic(carID))
+	}
+}
+
+// racing reports whether the car is in an unfinished race
+func (a *AllData) racing(carID string) bool {
+	car, ok := a.CarMap[carID]
+	if !ok || car.CurrentRace == nil {
+		return false
+	}
+	data, ok := car.CurrentRace.RaceData[carID]
+	return ok && data.RaceMode && !data.Finished
+}
@@ -0,0 +1,62 @@
+package master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelemetryEvaluate(t *testing.T) {
+	t0 := time.Now()
+	at := func(s float64) time.Time { return t0.Add(time.Duration(s * float64(time.Second))) }
+	rules := TelemetryRules{GPS: 2}
+
+	var m telemetryMonitor
+	_, ok := m.evaluate("1", rules, false, at(0))
+	assert.False(t, ok, "first evaluation of a car not racing is not an event")
+	assert.Equal(t, TelemetryOffline, m.cars["1"].Status, "never seen")
+
+	e, ok := m.evaluate("2", rules, true, at(0))
+	assert.True(t, ok, "a racing car is reported from the first evaluation")
+	assert.Equal(t, TelemetryOffline, e.Status)
+	assert.Empty(t, e.Previous)
+
+	m.seen("1", SensorPSU, at(0))
+	m.seen("1", SensorGPS, at(0))
+	e, ok = m.evaluate("1", rules, true, at(1))
+	assert.True(t, ok)
+	assert.Equal(t, TelemetryOnline, e.Status)
+	assert.Equal(t, TelemetryOffline, e.Previous)
+
+	m.seen("1", SensorPSU, at(3))
+	e, ok = m.evaluate("1", rules, true, at(3))
+	assert.True(t, ok)
+	assert.Equal(t, TelemetryDegraded, e.Status)
+	assert.Equal(t, []string{SensorGPS}, e.Stale)
+
+	_, ok = m.evaluate("1", rules, true, at(4))
+	assert.False(t, ok, "no change")
+
+	e, ok = m.evaluate("1", rules, true, at(9))
+	assert.True(t, ok)
+	assert.Equal(t, TelemetryOffline, e.Status)
+	assert.True(t, e.Racing)
+	assert.Len(t, m.eventsOf("1"), 3)
+}
+
+func TestLiveDataUpdatedOnChange(t *testing.T) {
+	a := AllData{LiveData: map[string]LiveDataInstance{}}
+
+	a.UpdateLiveDataCarTelemetry("1", TelemetryOnline, []string{})
+	a.UpdateLiveDataCarPositionCategory("1", []string{"A"}, []int{10}, 1)
+	updated := a.LiveData["1"].UpdatedAt
+
+	a.UpdateLiveDataCarTelemetry("1", TelemetryOnline, []string{})
+	a.UpdateLiveDataCarPositionCategory("1", []string{"A"}, []int{10}, 1)
+	assert.Equal(t, updated, a.LiveData["1"].UpdatedAt, "nothing changed")
+
+	a.UpdateLiveDataCarPositionCategory("1", []string{"A"}, []int{12}, 1)
+	assert.NotEqual(t, updated, a.LiveData["1"].UpdatedAt)
+	assert.Equal(t, []int{12}, a.LiveData["1"].Points)
+}
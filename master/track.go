@@ -0,0 +1,175 @@
+package master
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// GateLine is a line segment across the track, a lap or sector boundary is passed when a car's path crosses it
+type GateLine struct {
+	A GeoPoint `json:"a"`
+	B GeoPoint `json:"b"`
+}
+
+type Track struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Length      float64    `json:"length"`     // m, official length of one lap, taken from the centerline when 0
+	Centerline  []GeoPoint `json:"centerline"` // in driving order, closed by the start/finish line
+	StartFinish GateLine   `json:"startFinish"`
+	Sectors     []GateLine `json:"sectors"`    // sector lines in driving order, the start/finish line closes the last sector
+	PitLane     []GeoPoint `json:"pitLane"`    // polygon, empty when the track has no pit lane
+	MinLapTime  float64    `json:"minLapTime"` // s, crossings sooner than this after the previous one are GPS jitter, 0 for the default
+}
+
+const (
+	defaultMinLapTime = 10      // s
+	earthRadius       = 6371000 // m
+)
+
+// haversine is the great-circle distance between two points in meters
+func haversine(p, q GeoPoint) float64 {
+	lat1, lat2 := p.Lat*math.Pi/180, q.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (q.Lon - p.Lon) * math.Pi / 180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// LapLength is the official lap length, or the length of the centerline when none is given
+func (t Track) LapLength() float64 {
+	if t.Length > 0 {
+		return t.Length
+	}
+	var length float64
+	for i := 1; i < len(t.Centerline); i++ {
+		length += haversine(t.Centerline[i-1], t.Centerline[i])
+	}
+	return length
+}
+
+func (p GeoPoint) Validate() error {
+	if p.Lat < -90 || p.Lat > 90 || p.Lon < -180 || p.Lon > 180 {
+		return fmt.Errorf("coordinates %f, %f are out of range", p.Lat, p.Lon)
+	}
+	return nil
+}
+
+func (l GateLine) Validate() error {
+	if err := l.A.Validate(); err != nil {
+		return err
+	}
+	if err := l.B.Validate(); err != nil {
+		return err
+	}
+	if l.A == l.B {
+		return fmt.Errorf("line ends must not be the same point")
+	}
+	return nil
+}
+
+func (t Track) Validate() error {
+	if strings.TrimSpace(t.ID) == "" {
+		return fmt.Errorf("track ID must not be empty")
+	}
+	if err := t.StartFinish.Validate(); err != nil {
+		return fmt.Errorf("track '%s' start/finish line: %s", t.ID, err.Error())
+	}
+	for i, s := range t.Sectors {
+		if err := s.Validate(); err != nil {
+			return fmt.Errorf("track '%s' sector line %d: %s", t.ID, i+1, err.Error())
+		}
+	}
+	if t.MinLapTime < 0 || t.Length < 0 {
+		return fmt.Errorf("track '%s' length and minimum lap time must not be negative", t.ID)
+	}
+	if len(t.Centerline) == 1 {
+		return fmt.Errorf("track '%s' centerline needs at least two points", t.ID)
+	}
+	if len(t.PitLane) > 0 && len(t.PitLane) < 3 {
+		return fmt.Errorf("track '%s' pit lane needs at least three points", t.ID)
+	}
+	for _, points := range [][]GeoPoint{t.Centerline, t.PitLane} {
+		for _, p := range points {
+			if err := p.Validate(); err != nil {
+				return fmt.Errorf("track '%s': %s", t.ID, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// CheckRaceTrack checks that the race refers to a defined track when it is set to finish after a number of laps
+func (a *AllData) CheckRaceTrack(race Race) error {
+	if race.Laps < 0 {
+		return fmt.Errorf("race '%s' lap count must not be negative", race.RaceName)
+	}
+	if race.TrackID == "" {
+		if race.Laps > 0 {
+			return fmt.Errorf("race '%s' needs a track to count laps", race.RaceName)
+		}
+		return nil
+	}
+	if _, ok := a.Tracks[race.TrackID]; !ok {
+		return fmt.Errorf("race '%s' track '%s' is not defined", race.RaceName, race.TrackID)
+	}
+	return nil
+}
+
+func (a *AllData) GetTracks() []Track {
+	tracks := make([]Track, 0, len(a.Tracks))
+	for _, t := range a.Tracks {
+		tracks = append(tracks, t)
+	}
+	return tracks
+}
+
+func (a *AllData) UpdateTracks(tracks []Track) error {
+	updated := make(map[string]Track, len(tracks))
+	for _, t := range tracks {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if _, ok := updated[t.ID]; ok {
+			return fmt.Errorf("track '%s' is defined more than once", t.ID)
+		}
+		updated[t.ID] = t
+	}
+	a.Tracks = updated
+	a.deriveRaceLengths()
+	return nil
+}
+
+func (a *AllData) GetTrack(id string) (Track, error) {
+	t, ok := a.Tracks[id]
+	if !ok {
+		return t, fmt.Errorf("track '%s' not found", id)
+	}
+	return t, nil
+}
+
+// deriveRaceLengths sets the length of races without an explicit one from their track and lap count
+func (a *AllData) deriveRaceLengths() {
+	for key, race := range a.Races {
+		if race.TrackID == "" || (race.Length > 0 && !race.LengthFromTrack) {
+			continue
+		}
+		track, ok := a.Tracks[race.TrackID]
+		if !ok {
+			continue
+		}
+		laps := race.Laps
+		if laps == 0 {
+			laps = 1
+		}
+		race.Length = track.LapLength() * float64(laps)
+		race.LengthFromTrack = true
+		a.Races[key] = race
+	}
+}
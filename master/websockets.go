@@ -1,9 +1,13 @@
 package master
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
@@ -12,10 +16,110 @@ import (
 
 type Session struct {
 	Channel chan string
+	resync  atomic.Bool // a live data delta was dropped, the session gets a snapshot instead of the next one
+	mutex   sync.Mutex
+	topics  map[string]bool
+}
+
+// WebSocket topics. A session starts subscribed to live only.
+const (
+	TopicLive   = "live"   // snapshot and delta live data of every car
+	TopicAlerts = "alerts" // alerts, PSU mismatches, governor interventions, telemetry and the emergency stop
+)
+
+func carTopic(carID string) string         { return "car:" + carID }         // the car's live data and events
+func raceTopic(raceName string) string     { return "race:" + raceName }     // countdown and start
+func leaderboardTopic(group string) string { return "leaderboard:" + group } // the group's leaderboard when it changes
+
+// Envelope wraps every message sent to and received from a WebSocket client
+type Envelope struct {
+	Type  string          `json:"type"`
+	Topic string          `json:"topic"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// subscription is what a client sends in the data of subscribe and unsubscribe messages
+type subscription struct {
+	Topics []string `json:"topics"`
+}
+
+func validTopic(topic string) error {
+	if topic == TopicLive || topic == TopicAlerts {
+		return nil
+	}
+	for _, prefix := range []string{"car:", "race:", "leaderboard:"} {
+		if strings.HasPrefix(topic, prefix) && len(topic) > len(prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown topic '%s'", topic)
+}
+
+func newSession() *Session {
+	return &Session{
+		Channel: make(chan string, 10), // Buffered channel to handle messages
+		topics:  map[string]bool{TopicLive: true},
+	}
+}
+
+func (s *Session) subscribed(topic string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.topics[topic]
+}
+
+// subscribedCars returns the IDs of the cars the session follows
+func (s *Session) subscribedCars() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var cars []string
+	for topic := range s.topics {
+		if carID, ok := strings.CutPrefix(topic, "car:"); ok {
+			cars = append(cars, carID)
+		}
+	}
+	return cars
+}
+
+func (s *Session) subscriptions() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// send queues a message for the session, it is dropped when the session is busy
+func (s *Session) send(msg string) bool {
+	select {
+	case s.Channel <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func envelope(msgType, topic string, data interface{}) (string, error) {
+	raw, ok := data.(json.RawMessage)
+	if !ok {
+		var err error
+		if raw, err = json.Marshal(data); err != nil {
+			return "", errors.Wrap(err, "JSON")
+		}
+	}
+	msg, err := json.Marshal(Envelope{Type: msgType, Topic: topic, Data: raw})
+	if err != nil {
+		return "", errors.Wrap(err, "JSON")
+	}
+	return string(msg), nil
 }
 
 var Sessions = map[int]*Session{}
 var SessionsMutex = sync.RWMutex{}
+var nextSessionID int
 
 func AddSession(s *Session) int {
 	SessionsMutex.Lock()
@@ -23,7 +127,8 @@ func AddSession(s *Session) int {
 	if s == nil {
 		return -1
 	}
-	id := len(Sessions)
+	id := nextSessionID
+	nextSessionID++
 	Sessions[id] = s
 	logrus.Debugf("Session %d added", id)
 	return id
@@ -41,15 +146,36 @@ func RemoveSession(id int) {
 	}
 }
 
-func BroadcastMessage(msg string) {
+// Publish sends a message to the sessions subscribed to any of the topics. Each session gets it once,
+// under the first of the topics it is subscribed to.
+func Publish(msgType string, data interface{}, topics ...string) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		logrus.WithError(errors.Wrap(err, "JSON")).Error("Error")
+		return
+	}
+	msgs := map[string]string{} // map of [topic]
 	SessionsMutex.RLock()
 	defer SessionsMutex.RUnlock()
 	for id, s := range Sessions {
-		select {
-		case s.Channel <- msg:
-			logrus.Debugf("Message sent to session %d", id)
-		default:
-			logrus.Debugf("Session %d is busy, message not sent", id)
+		for _, topic := range topics {
+			if !s.subscribed(topic) {
+				continue
+			}
+			msg, ok := msgs[topic]
+			if !ok {
+				if msg, err = envelope(msgType, topic, json.RawMessage(raw)); err != nil {
+					logrus.WithError(err).Error("Error")
+					return
+				}
+				msgs[topic] = msg
+			}
+			if s.send(msg) {
+				logrus.Debugf("Message sent to session %d", id)
+			} else {
+				logrus.Debugf("Session %d is busy, message not sent", id)
+			}
+			break
 		}
 	}
 }
@@ -60,19 +186,128 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
+// SendLiveData sends the cars whose live data changed since the last call: deltas on the live topic, where sessions
+// that missed one get a snapshot, and the whole live data of each changed car on its car topic.
 func (srv *Service) SendLiveData() {
+	srv.live.mutex.Lock()
+	defer srv.live.mutex.Unlock()
+	delta, changed := srv.live.delta(srv.AllData.LiveDataByCar())
+	var deltaMsg string
+	if changed {
+		var err error
+		if deltaMsg, err = envelope(LiveDelta, TopicLive, delta); err != nil {
+			logrus.WithError(err).Error("Error")
+			return
+		}
+	}
+
 	SessionsMutex.RLock()
 	defer SessionsMutex.RUnlock()
 	for id, s := range Sessions {
-		select {
-		case s.Channel <- srv.AllData.LiveDataToJson():
+		if changed {
+			for _, carID := range s.subscribedCars() {
+				if data, ok := delta.Cars[carID]; ok {
+					srv.sendCar(s, carID, data)
+				}
+			}
+		}
+		if !s.subscribed(TopicLive) {
+			continue
+		}
+		if s.resync.Load() {
+			srv.sendSnapshotLocked(s)
+			continue
+		}
+		if !changed {
+			continue
+		}
+		if s.send(deltaMsg) {
 			logrus.Debugf("Live data sent to session %d", id)
-		default:
+		} else {
+			s.resync.Store(true)
 			logrus.Debugf("Session %d is busy, live data not sent", id)
 		}
 	}
 }
 
+// handleClientMessage serves subscribe, unsubscribe and resync requests
+func (srv *Service) handleClientMessage(s *Session, req []byte) {
+	var msg Envelope
+	if err := json.Unmarshal(req, &msg); err != nil {
+		srv.sendClientError(s, errors.Wrap(err, "Unmarshal"))
+		return
+	}
+
+	switch msg.Type {
+	case LiveResync:
+		if !s.subscribed(TopicLive) {
+			srv.sendClientError(s, fmt.Errorf("not subscribed to '%s'", TopicLive))
+			return
+		}
+		srv.sendSnapshot(s)
+		return
+	case "subscribe", "unsubscribe":
+	default:
+		srv.sendClientError(s, fmt.Errorf("unknown message type '%s'", msg.Type))
+		return
+	}
+
+	var sub subscription
+	if err := json.Unmarshal(msg.Data, &sub); err != nil {
+		srv.sendClientError(s, errors.Wrap(err, "Unmarshal"))
+		return
+	}
+	for _, topic := range sub.Topics {
+		if err := validTopic(topic); err != nil {
+			srv.sendClientError(s, err)
+			return
+		}
+	}
+	var added []string
+	s.mutex.Lock()
+	for _, topic := range sub.Topics {
+		if msg.Type == "unsubscribe" {
+			delete(s.topics, topic)
+		} else if !s.topics[topic] {
+			s.topics[topic] = true
+			added = append(added, topic)
+		}
+	}
+	s.mutex.Unlock()
+
+	if reply, err := envelope("subscriptions", "", subscription{Topics: s.subscriptions()}); err == nil {
+		s.send(reply)
+	}
+	// New subscribers start from the current state
+	for _, topic := range added {
+		if topic == TopicLive {
+			srv.sendSnapshot(s)
+		} else if carID, ok := strings.CutPrefix(topic, "car:"); ok {
+			srv.live.mutex.Lock()
+			if data, ok := srv.live.last[carID]; ok {
+				srv.sendCar(s, carID, data)
+			}
+			srv.live.mutex.Unlock()
+		}
+	}
+}
+
+func (srv *Service) sendCar(s *Session, carID string, data json.RawMessage) {
+	msg, err := envelope("car", carTopic(carID), data)
+	if err != nil {
+		logrus.WithError(err).Error("Error")
+		return
+	}
+	s.send(msg)
+}
+
+func (srv *Service) sendClientError(s *Session, err error) {
+	logrus.WithError(err).Debug("Websocket client message")
+	if msg, err := envelope("error", "", map[string]string{"message": err.Error()}); err == nil {
+		s.send(msg)
+	}
+}
+
 func (srv *Service) wsHandler(w http.ResponseWriter, r *http.Request) {
 	var err error
 	logrus.Debugf("Websocket request %s %s", r.Method, r.URL.Path)
@@ -113,13 +348,12 @@ func (mgmt *Service) wsBase(w http.ResponseWriter, r *http.Request) error {
 		return nil
 	})
 
-	session := Session{
-		Channel: make(chan string, 10), // Buffered channel to handle messages
-	}
-	id := AddSession(&session)
+	session := newSession()
+	id := AddSession(session)
 	if id < 0 {
 		return errors.New("Failed to add session")
 	}
+	mgmt.sendSnapshot(session)
 
 	// Websocket message sender thread
 	go func() {
@@ -155,9 +389,9 @@ func (mgmt *Service) wsBase(w http.ResponseWriter, r *http.Request) error {
 			break
 		}
 
+		// Clients can only manage their own subscriptions, nothing they send is passed on to other sessions
 		if reqType == websocket.TextMessage {
-			BroadcastMessage(string(req)) // Broadcast to all sessions
-
+			mgmt.handleClientMessage(session, req)
 		}
 	}
 	RemoveSession(id) // Clean up session on exit
@@ -178,7 +412,7 @@ func (s *Service) wsBasePage(w http.ResponseWriter, r *http.Request) error {
 	<body>
 		<h2>Websocket Tester</h2>
 		<form name="publish">
-			<input type="text" name="message">
+			<input type="text" name="message" size="80" value='{"type":"subscribe","data":{"topics":["alerts"]}}'>
 			<input type="submit" value="Send">
 		</form>
 